@@ -1,19 +1,104 @@
 package fetcher
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
-
-	"code.cloudfoundry.org/archiver/extractor"
 )
 
+// HTTPStatusError is for when the server responds to a download with an
+// error status such as 404 Not Found.
+type HTTPStatusError struct {
+	Source     string
+	StatusCode int
+	Status     string
+}
+
+// Error returns the HTTP status error message.
+func (e HTTPStatusError) Error() string {
+	return fmt.Sprintf("downloading %s: %s", e.Source, e.Status)
+}
+
+// NetworkError is for when a download fails before the server responds, or
+// while the response is being read.
+type NetworkError struct {
+	Source string
+	Err    error
+}
+
+// Error returns the network error message.
+func (e NetworkError) Error() string {
+	return fmt.Sprintf("downloading %s: %s", e.Source, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// UnsupportedFormatError is for when an archive is not in a format that can
+// be extracted.
+type UnsupportedFormatError struct {
+	Source string
+}
+
+// Error returns the unsupported format error message.
+func (e UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("%s is not a supported archive format", e.Source)
+}
+
+// CorruptArchiveError is for when an archive is in a supported format but can
+// not be extracted.
+type CorruptArchiveError struct {
+	Source string
+	Err    error
+}
+
+// Error returns the corrupt archive error message.
+func (e CorruptArchiveError) Error() string {
+	return fmt.Sprintf("extracting %s: %s", e.Source, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e CorruptArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// ArchiveOptions are the optional settings of an ArchiveFetcher.
+type ArchiveOptions struct {
+	// Format is one of the ArchiveFormats. Without it, the format is detected
+	// from the contents of the archive.
+	Format string
+
+	// Subtree places only part of the archive.
+	Subtree
+}
+
 // ArchiveFetcher fetches source code from a remote archive.
 type ArchiveFetcher struct {
 	source, destination string
+	options             ArchiveOptions
+
+	// checksum is the checksum the archive is declared to have in the
+	// manifest, in the form "<algorithm>:<hex>". It may be empty.
+	checksum string
+
+	// pinned is the checksum the download must have, resolved is the
+	// checksum of the last download.
+	pinned, resolved string
+
+	// bytes is how many bytes the last Fetch downloaded.
+	bytes int64
+
+	// paths are the files and directories extracted from the archive.
+	paths []string
+
+	cache *Cache
 }
 
 // GetSource gets the path to the source and is required by the Fetcher
@@ -28,58 +113,263 @@ func (af *ArchiveFetcher) GetDestination() string {
 	return af.destination
 }
 
-func (af *ArchiveFetcher) downloadFile() (*os.File, error) {
+// Resolved returns the sha256 checksum of the downloaded archive and is
+// required by the Pinner interface.
+func (af *ArchiveFetcher) Resolved() string {
+	return af.resolved
+}
+
+// Pin sets the checksum the archive must have and is required by the Pinner
+// interface.
+func (af *ArchiveFetcher) Pin(identity string) {
+	af.pinned = identity
+}
+
+// BytesFetched returns how many bytes the last Fetch downloaded and is
+// required by the Counter interface. It is zero when the archive came from the
+// cache.
+func (af *ArchiveFetcher) BytesFetched() int64 {
+	return af.bytes
+}
+
+// SetCache sets the cache to keep downloaded archives in and is required by
+// the Cacher interface.
+func (af *ArchiveFetcher) SetCache(cache *Cache) {
+	af.cache = cache
+}
+
+// downloadFile downloads the archive into a temporary file in dir, or in the
+// default directory for temporary files if dir is empty.
+func (af *ArchiveFetcher) downloadFile(ctx context.Context, dir string) (_ *os.File, err error) {
 	// Figure out the filename
 	tokens := strings.Split(af.source, "/")
 	fileName := tokens[len(tokens)-1]
 
 	// Create the file that we will write to.
-	tempFile, err := ioutil.TempFile("", fileName)
+	tempFile, err := ioutil.TempFile(dir, fileName)
 	if err != nil {
 		return nil, err
 	}
 	defer tempFile.Close()
 
+	// Don't leave a partial download behind.
+	defer func() {
+		if err != nil {
+			os.Remove(tempFile.Name())
+		}
+	}()
+
 	// Download the remote file
-	response, err := http.Get(af.source)
+	request, err := http.NewRequestWithContext(ctx, "GET", af.source, nil)
 	if err != nil {
 		return nil, err
 	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, NetworkError{af.source, err}
+	}
 	defer response.Body.Close()
 
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, HTTPStatusError{af.source, response.StatusCode, response.Status}
+	}
+
 	// Write the downloaded file to the temp file
-	_, err = io.Copy(tempFile, response.Body)
+	af.bytes, err = io.Copy(tempFile, response.Body)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, NetworkError{af.source, err}
 	}
 
 	return tempFile, err
 }
 
+// Paths returns the files and directories extracted from the archive and is
+// required by the Placer interface.
+func (af *ArchiveFetcher) Paths() []string {
+	return af.paths
+}
+
 // Fetch the source code. Required by the Fetcher interface.
-func (af *ArchiveFetcher) Fetch(baseDir string) error {
-	file, err := af.downloadFile()
+func (af *ArchiveFetcher) Fetch(ctx context.Context, baseDir string) error {
+	return af.fetch(ctx, baseDir, nil)
+}
+
+// Update extracts the archive again if its checksum has changed, replacing
+// what the previous archive placed. Required by the Updater interface.
+func (af *ArchiveFetcher) Update(ctx context.Context, baseDir string, previous Placement) error {
+	return af.fetch(ctx, baseDir, &previous)
+}
+
+// fetch downloads, verifies and extracts the archive. With a previous
+// placement, nothing is extracted if the archive hasn't changed.
+func (af *ArchiveFetcher) fetch(ctx context.Context, baseDir string, previous *Placement) (err error) {
+	af.bytes = 0
+
+	archive, done, err := af.archive(ctx)
 	if err != nil {
 		return err
 	}
 
+	// Clean up
+	defer done()
+
+	// Verify the archive before anything is extracted from it.
+	if af.checksum != "" {
+		var sum string
+		sum, err = checksumFile(archive, af.checksum)
+		if err != nil {
+			return err
+		}
+
+		if sum != af.checksum {
+			return ChecksumError{af.source, af.checksum, sum}
+		}
+	}
+
+	af.resolved, err = hashFile(archive)
+	if err != nil {
+		return err
+	}
+
+	if af.pinned != "" && af.pinned != af.resolved {
+		return PinError{af.source, af.pinned, af.resolved}
+	}
+
+	if previous != nil && previous.Resolved == af.resolved && exists(previous.Paths) {
+		af.paths = previous.Paths
+		return nil
+	}
+
 	dest := filepath.Join(baseDir, af.destination)
+	defer cleanup(dest, &err)()
 
-	extractor := extractor.NewDetectable()
-	extractor.Extract(file.Name(), dest)
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		return err
+	}
 
-	// Clean up
-	os.Remove(file.Name())
+	// Extract into a temporary directory first and only move the result into
+	// place once it is complete, since dest may be shared with other
+	// projects.
+	tempDir, err := ioutil.TempDir(dest, ".tasc-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	format := af.options.Format
+	if format == "" {
+		format, err = detectFormat(archive)
+		if err != nil {
+			return err
+		}
+		if format == "" {
+			return UnsupportedFormatError{af.source}
+		}
+	}
+
+	if err = extract(archive, format, tempDir); err != nil {
+		return CorruptArchiveError{af.source, err}
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	root := tempDir
+	if af.options.selects() {
+		root, err = af.options.root(af.source, tempDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replace what the previous version of the archive placed.
+	if previous != nil {
+		for _, path := range previous.Paths {
+			if err = os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	af.paths, err = moveContents(root, dest)
+	return err
+}
+
+// archive returns the path to the archive, either from the cache or
+// downloaded to a temporary file, and a function that cleans it up once it
+// is no longer needed.
+func (af *ArchiveFetcher) archive(ctx context.Context) (string, func(), error) {
+	if af.cache != nil {
+		// Look the archive up by its checksum if we know it.
+		checksum := af.pinned
+		if checksum == "" {
+			checksum = af.checksum
+		}
+
+		path, err := af.cache.archive(ctx, af.source, checksum, af.downloadFile)
+		return path, func() {}, err
+	}
+
+	file, err := af.downloadFile(ctx, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	return file.Name(), func() { os.Remove(file.Name()) }, nil
+}
+
+// moveContents moves everything in the source directory into the destination
+// directory, merging directories that are already there, and returns the
+// paths it placed. Directories that already existed are not among them, so
+// removing the paths later leaves what other projects placed there alone.
+func moveContents(source, destination string) ([]string, error) {
+	objects, err := ioutil.ReadDir(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, obj := range objects {
+		placed, err := merge(
+			filepath.Join(source, obj.Name()), filepath.Join(destination, obj.Name()),
+		)
+		paths = append(paths, placed...)
+		if err != nil {
+			return paths, err
+		}
+	}
+
+	return paths, nil
+}
+
+// exists reports whether all of the paths exist.
+func exists(paths []string) bool {
+	for _, path := range paths {
+		if _, err := os.Lstat(path); err != nil {
+			return false
+		}
+	}
 
-	return nil
+	return true
 }
 
-// NewArchiveFetcher gets a new ArchiveFetcher.
-func NewArchiveFetcher(source, destination string) *ArchiveFetcher {
+// NewArchiveFetcher gets a new ArchiveFetcher. The checksum is optional.
+func NewArchiveFetcher(source, destination, checksum string, options ArchiveOptions) *ArchiveFetcher {
 	zf := new(ArchiveFetcher)
 
 	zf.source = source
 	zf.destination = destination
+	zf.checksum = checksum
+	zf.options = options
 
 	return zf
 }
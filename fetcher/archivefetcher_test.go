@@ -0,0 +1,75 @@
+package fetcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTree creates the files, relative to dir, with their contents.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMoveContents(t *testing.T) {
+	dest := t.TempDir()
+
+	// Another project already placed theme/, and an earlier run placed
+	// repo-1.2/.
+	writeTree(t, dest, map[string]string{
+		"theme/boost/config.php": "boost",
+		"repo-1.2/old.txt":       "old",
+		"replaced.txt":           "old",
+	})
+
+	source := t.TempDir()
+	writeTree(t, source, map[string]string{
+		"theme/custom/config.php": "custom",
+		"repo-1.2/new.txt":        "new",
+		"replaced.txt":            "new",
+		"added.txt":               "added",
+	})
+
+	paths, err := moveContents(source, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(dest, "added.txt"),
+		filepath.Join(dest, "replaced.txt"),
+		filepath.Join(dest, "repo-1.2", "new.txt"),
+		filepath.Join(dest, "theme", "custom"),
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("placed %v, expected %v", paths, expected)
+	}
+
+	for name, contents := range map[string]string{
+		"theme/boost/config.php":  "boost",
+		"theme/custom/config.php": "custom",
+		"repo-1.2/old.txt":        "old",
+		"repo-1.2/new.txt":        "new",
+		"replaced.txt":            "new",
+		"added.txt":               "added",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Errorf("reading %s: %s", name, err)
+		} else if string(b) != contents {
+			t.Errorf("%s contains %q, expected %q", name, b, contents)
+		}
+	}
+}
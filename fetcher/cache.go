@@ -0,0 +1,232 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// OfflineError is for when something has to be fetched over the network
+// while working offline.
+type OfflineError struct {
+	Source string
+}
+
+// Error returns the offline error message.
+func (e OfflineError) Error() string {
+	return fmt.Sprintf("%s is not in the cache and can't be fetched offline", e.Source)
+}
+
+// Cacher is implemented by fetchers that can keep what they fetch in a Cache.
+type Cacher interface {
+	SetCache(cache *Cache)
+}
+
+// checksumPattern matches checksums in the form "<algorithm>:<hex>".
+var checksumPattern = regexp.MustCompile(`^[a-z0-9]+:[0-9a-f]+$`)
+
+// Cache is a local directory where fetched content is kept between runs. Git
+// repositories are kept as bare mirrors, archives are stored by checksum with
+// an index from their URL.
+type Cache struct {
+	Dir string
+
+	// Offline means nothing may be fetched over the network. Only what is
+	// already in the cache can be used.
+	Offline bool
+
+	// locks makes sure only one goroutine at a time updates an entry.
+	locks map[string]*sync.Mutex
+	mutex sync.Mutex
+}
+
+// NewCache creates a new Cache in dir.
+func NewCache(dir string, offline bool) *Cache {
+	c := new(Cache)
+
+	c.Dir = dir
+	c.Offline = offline
+	c.locks = make(map[string]*sync.Mutex)
+
+	return c
+}
+
+// key returns a name for a source that is safe to use as a filename.
+func (c *Cache) key(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// lock locks the cache entry for a key and returns a function to unlock it.
+func (c *Cache) lock(key string) func() {
+	c.mutex.Lock()
+	l, ok := c.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[key] = l
+	}
+	c.mutex.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// gitMirror returns the path to a bare mirror of a git repository. The mirror
+// is cloned if it is not in the cache yet, and updated otherwise unless
+// working offline. env is added to the environment of git, for credentials.
+func (c *Cache) gitMirror(ctx context.Context, source string, env []string) (string, error) {
+	key := c.key(source)
+	defer c.lock(key)()
+
+	mirror := filepath.Join(c.Dir, "git", key+".git")
+
+	if _, err := os.Stat(mirror); err == nil {
+		if !c.Offline {
+			_, err = runEnv(ctx, mirror, env, "git", "remote", "update", "--prune")
+		}
+		return mirror, err
+	}
+
+	if c.Offline {
+		return "", OfflineError{source}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(mirror), 0755); err != nil {
+		return "", err
+	}
+
+	// Clone next to the mirror and move it into place when complete, so an
+	// interrupted clone never looks like a usable mirror.
+	tempDir, err := ioutil.TempDir(filepath.Dir(mirror), key+".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+
+	_, err = runEnv(ctx, "", env, "git", "clone", "--mirror", source, tempDir)
+	if err != nil {
+		return "", err
+	}
+
+	return mirror, os.Rename(tempDir, mirror)
+}
+
+// archivePath returns where an archive with the given checksum is stored.
+func (c *Cache) archivePath(checksum string) string {
+	return filepath.Join(
+		c.Dir, "archives", strings.Replace(checksum, ":", string(os.PathSeparator), 1),
+	)
+}
+
+// archive returns the path to a cached copy of the archive at source. If
+// checksum is set, in any of the supported algorithms, the archive with that
+// checksum is used, regardless of where it was downloaded from. Otherwise the
+// archive is downloaded again, since what is behind the URL may have changed,
+// unless working offline, in which case the archive last downloaded from
+// source is used. download is called to fetch the archive into a temporary
+// file in the given directory.
+func (c *Cache) archive(
+	ctx context.Context,
+	source, checksum string,
+	download func(ctx context.Context, dir string) (*os.File, error),
+) (string, error) {
+	key := c.key(source)
+	defer c.lock(key)()
+
+	index := filepath.Join(c.Dir, "archives", "urls", key)
+
+	if path, ok := c.cachedArchive(checksum); ok {
+		return path, nil
+	}
+
+	if c.Offline {
+		// The archive last downloaded from source is checked against the
+		// checksum by the fetcher, if there is one.
+		if b, err := ioutil.ReadFile(index); err == nil {
+			if path, ok := c.cachedArchive(strings.TrimSpace(string(b))); ok {
+				return path, nil
+			}
+		}
+
+		return "", OfflineError{source}
+	}
+
+	tempDir := filepath.Join(c.Dir, "archives", "tmp")
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return "", err
+	}
+
+	file, err := download(ctx, tempDir)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	sum, err := hashFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+
+	path := c.archivePath(sum)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return "", err
+	}
+
+	// Archives are stored by their sha256 checksum. Link one declared with
+	// another algorithm under that checksum too, so it is found next time.
+	if checksumPattern.MatchString(checksum) && !strings.HasPrefix(checksum, "sha256:") {
+		if err := c.linkArchive(path, checksum); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(index), 0755); err != nil {
+		return "", err
+	}
+
+	return path, ioutil.WriteFile(index, []byte(sum+"\n"), 0644)
+}
+
+// cachedArchive returns the path to the archive with the given checksum, if it
+// is in the cache.
+func (c *Cache) cachedArchive(checksum string) (string, bool) {
+	if !checksumPattern.MatchString(checksum) {
+		return "", false
+	}
+
+	path := c.archivePath(checksum)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+
+	return path, true
+}
+
+// linkArchive links the archive at path under its checksum in the algorithm
+// of the given checksum.
+func (c *Cache) linkArchive(path, checksum string) error {
+	sum, err := checksumFile(path, checksum)
+	if err != nil {
+		return err
+	}
+
+	link := c.archivePath(sum)
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Link(path, link)
+}
@@ -0,0 +1,112 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCacheArchive(t *testing.T) {
+	dir := t.TempDir()
+	source := "https://example.com/archive/master.zip"
+
+	// download serves contents, like a server whose response can change.
+	var contents string
+	downloads := 0
+	download := func(ctx context.Context, dir string) (*os.File, error) {
+		downloads++
+		f, err := ioutil.TempFile(dir, "download")
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		_, err = f.WriteString(contents)
+		return f, err
+	}
+
+	// get returns what the cache has for source, and checks that it was
+	// downloaded as often as expected.
+	get := func(c *Cache, checksum string, expected int) string {
+		t.Helper()
+
+		path, err := c.archive(context.Background(), source, checksum, download)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if downloads != expected {
+			t.Errorf("downloaded %d times, expected %d", downloads, expected)
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	online := NewCache(dir, false)
+
+	contents = "v1"
+	if got := get(online, "", 1); got != "v1" {
+		t.Errorf("got %q, expected v1", got)
+	}
+
+	// An unpinned URL is downloaded again when online.
+	contents = "v2"
+	if got := get(online, "", 2); got != "v2" {
+		t.Errorf("got %q, expected v2", got)
+	}
+
+	// A pinned checksum that is cached is not downloaded again.
+	file, err := ioutil.TempFile(dir, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("v1")
+	file.Close()
+	v1, err := hashFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get(online, v1, 2); got != "v1" {
+		t.Errorf("got %q, expected v1", got)
+	}
+
+	// Checksums in other algorithms are found again too.
+	contents = "v3"
+	file, err = ioutil.TempFile(dir, "v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("v3")
+	file.Close()
+	for i, algorithm := range []string{"md5", "sha512"} {
+		sum, err := checksumFile(file.Name(), algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := get(online, sum, 3+i); got != "v3" {
+			t.Errorf("got %q, expected v3", got)
+		}
+		if got := get(online, sum, 3+i); got != "v3" {
+			t.Errorf("got %q, expected v3 from the cache", got)
+		}
+		if got := get(NewCache(dir, true), sum, 3+i); got != "v3" {
+			t.Errorf("got %q, expected v3 offline", got)
+		}
+	}
+
+	// Offline, the archive last downloaded from the URL is used.
+	offline := NewCache(dir, true)
+	if got := get(offline, "", 4); got != "v3" {
+		t.Errorf("got %q, expected v3", got)
+	}
+
+	// And nothing else.
+	_, err = offline.archive(context.Background(), "https://example.com/other.zip", "", download)
+	if !errors.As(err, &OfflineError{}) {
+		t.Errorf("got error %v, expected an OfflineError", err)
+	}
+}
@@ -0,0 +1,139 @@
+package fetcher
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hashes are the supported checksum algorithms.
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// ChecksumError is for when a file does not have the checksum it should.
+type ChecksumError struct {
+	Source   string
+	Expected string
+	Actual   string
+}
+
+// Error returns the checksum error message.
+func (e ChecksumError) Error() string {
+	return fmt.Sprintf(
+		"%s has checksum %s, expected %s", e.Source, e.Actual, e.Expected,
+	)
+}
+
+// ParseChecksum checks that a checksum is in the form "<algorithm>:<hex>",
+// for example "sha256:9f86d0...", with a supported algorithm. It returns the
+// checksum with the hex digits in lower case.
+func ParseChecksum(checksum string) (string, error) {
+	tokens := strings.SplitN(checksum, ":", 2)
+	if len(tokens) != 2 {
+		return "", fmt.Errorf("checksum %q is not in the form <algorithm>:<hex>", checksum)
+	}
+
+	algorithm, sum := strings.ToLower(tokens[0]), strings.ToLower(tokens[1])
+
+	h, ok := hashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("checksum algorithm %q is not supported", algorithm)
+	}
+
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != h().Size() {
+		return "", fmt.Errorf("checksum %q is not a valid %s checksum", checksum, algorithm)
+	}
+
+	return algorithm + ":" + sum, nil
+}
+
+// checksumFile returns the checksum of a file in the form "<algorithm>:<hex>"
+// using the same algorithm as the given checksum.
+func checksumFile(filename, checksum string) (string, error) {
+	algorithm := strings.SplitN(checksum, ":", 2)[0]
+
+	h, ok := hashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("checksum algorithm %q is not supported", algorithm)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hh := h()
+	if _, err := io.Copy(hh, f); err != nil {
+		return "", err
+	}
+
+	return algorithm + ":" + hex.EncodeToString(hh.Sum(nil)), nil
+}
+
+// hashFile returns the sha256 checksum of a file in the form "sha256:<hex>".
+func hashFile(filename string) (string, error) {
+	return checksumFile(filename, "sha256")
+}
+
+// hashTree returns a sha256 checksum of a file or directory in the form
+// "sha256:<hex>". For directories, the relative path and content of every
+// regular file is hashed in lexical order so the result does not depend on
+// the order the filesystem returns entries in.
+func hashTree(root string) (string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return hashFile(root)
+	}
+
+	var paths []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, filepath.ToSlash(rel))
+		h.Write([]byte{0})
+
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
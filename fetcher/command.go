@@ -0,0 +1,84 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CommandError is for when an external command such as git or svn fails.
+type CommandError struct {
+	// Command is the program and its subcommand, for example "git clone". The
+	// other arguments are left out since they may contain credentials.
+	Command string
+
+	// Stderr is what the command wrote to stderr.
+	Stderr string
+
+	Err error
+}
+
+// Error returns the command error message.
+func (e CommandError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s: %s", e.Command, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", e.Command, e.Err, e.Stderr)
+}
+
+// Unwrap returns the underlying error.
+func (e CommandError) Unwrap() error {
+	return e.Err
+}
+
+// run runs a command in dir and returns what it wrote to stdout. The command
+// is killed when ctx is done, in which case the context's error is returned.
+func run(ctx context.Context, dir, name string, args ...string) (string, error) {
+	return runEnv(ctx, dir, nil, name, args...)
+}
+
+// runEnv is like run, but adds env to the environment of the command.
+func runEnv(ctx context.Context, dir string, env []string, name string, args ...string) (string, error) {
+	return execute(ctx, dir, env, "", name, args...)
+}
+
+// runInput is like run, but writes input to the command's stdin. Use it for
+// secrets that shouldn't be seen in the process list.
+func runInput(ctx context.Context, dir, input, name string, args ...string) (string, error) {
+	return execute(ctx, dir, nil, input, name, args...)
+}
+
+// execute runs a command for run, runEnv and runInput.
+func execute(ctx context.Context, dir string, env []string, input, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		command := name
+		if len(args) > 0 {
+			command += " " + args[0]
+		}
+
+		return "", CommandError{command, strings.TrimSpace(stderr.String()), err}
+	}
+
+	return stdout.String(), nil
+}
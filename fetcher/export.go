@@ -0,0 +1,117 @@
+package fetcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExportFilename is the file that records where an exported project came
+// from, since it has no .git or .svn directory to tell.
+const ExportFilename = ".tasc-export"
+
+// Exporter is implemented by fetchers that can place a plain tree, without
+// the version control metadata, like git archive and svn export do.
+type Exporter interface {
+	// Export makes the next Fetch or Update place a plain tree.
+	Export()
+}
+
+// Redacter is implemented by fetchers that record their source in the
+// destination, so that secrets in it can be masked.
+type Redacter interface {
+	// SetRedact sets the function that masks secrets in the source.
+	SetRedact(redact func(string) string)
+}
+
+// ExportInfo is what is recorded in the ExportFilename of an exported
+// project.
+type ExportInfo struct {
+	Provider string `yaml:"provider"`
+	Source   string `yaml:"source"`
+	Version  string `yaml:"version,omitempty"`
+	Resolved string `yaml:"resolved"`
+}
+
+// exportTree places a plain tree in dest. checkout is called to check the
+// project out into an empty temporary directory inside dest, and returns what
+// to record about it. The checkout is then turned into a plain tree, of which
+// the subtree is moved into dest, since dest may be shared with other
+// projects. Secrets in the recorded source are masked by redact, which may be
+// nil. It returns the paths that were placed.
+func exportTree(dest, metadata string, subtree Subtree, redact func(string) string, checkout func(dir string) (ExportInfo, error)) ([]string, error) {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+
+	tempDir, err := ioutil.TempDir(dest, ".tasc-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	info, err := checkout(tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := removeMetadata(tempDir, metadata); err != nil {
+		return nil, err
+	}
+
+	root := tempDir
+	if subtree.selects() {
+		root, err = subtree.root(info.Source, tempDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeExportInfo(root, info, redact); err != nil {
+		return nil, err
+	}
+
+	return moveContents(root, dest)
+}
+
+// removeMetadata turns the checkout in dir into a plain tree by removing
+// every metadata directory (or file, for git submodules) named metadata.
+func removeMetadata(dir, metadata string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.Name() != metadata {
+			return nil
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+}
+
+// writeExportInfo records info in the ExportFilename in dir, with secrets in
+// the source masked by redact, which may be nil.
+func writeExportInfo(dir string, info ExportInfo, redact func(string) string) error {
+	// Never record credentials from the source.
+	info.Source, _ = splitUserinfo(info.Source)
+	if redact != nil {
+		info.Source = redact(info.Source)
+	}
+
+	b, err := yaml.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, ExportFilename), b, 0644)
+}
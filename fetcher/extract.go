@@ -0,0 +1,295 @@
+package fetcher
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// The archive formats that can be extracted.
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+	FormatTarBz = "tar.bz2"
+	FormatTarXz = "tar.xz"
+	FormatTarZs = "tar.zst"
+)
+
+// ArchiveFormats are the names of the archive formats, as given to the format
+// setting of a project.
+var ArchiveFormats = []string{
+	FormatZip, FormatTar, FormatTarGz, FormatTarBz, FormatTarXz, FormatTarZs,
+}
+
+// archiveMagic are the leading bytes of each format. Compressed files are
+// assumed to be compressed tar archives.
+var archiveMagic = []struct {
+	format string
+	magic  []byte
+}{
+	{FormatZip, []byte("PK\x03\x04")},
+	{FormatZip, []byte("PK\x05\x06")},
+	{FormatTarGz, []byte("\x1f\x8b")},
+	{FormatTarBz, []byte("BZh")},
+	{FormatTarXz, []byte("\xfd7zXZ\x00")},
+	{FormatTarZs, []byte("\x28\xb5\x2f\xfd")},
+}
+
+// tarMagic is found at tarMagicOffset in the header of tar archives.
+var tarMagic = []byte("ustar")
+
+const tarMagicOffset = 257
+
+// detectFormat returns the format of an archive from its leading bytes, or
+// an empty string if it isn't a supported archive.
+func detectFormat(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	for _, am := range archiveMagic {
+		if bytes.HasPrefix(header, am.magic) {
+			return am.format, nil
+		}
+	}
+
+	if n > tarMagicOffset && bytes.HasPrefix(header[tarMagicOffset:], tarMagic) {
+		return FormatTar, nil
+	}
+
+	return "", nil
+}
+
+// extract extracts an archive of the given format into dir.
+func extract(archive, format, dir string) error {
+	if format == FormatZip {
+		return extractZip(archive, dir)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader
+	switch format {
+	case FormatTar:
+		r = f
+	case FormatTarGz:
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	case FormatTarBz:
+		r = bzip2.NewReader(f)
+	case FormatTarXz:
+		r, err = xz.NewReader(f)
+		if err != nil {
+			return err
+		}
+	case FormatTarZs:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return fmt.Errorf("unknown archive format %q", format)
+	}
+
+	return extractTar(r, dir)
+}
+
+// extractZip extracts a zip archive into dir.
+func extractZip(archive, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		path, err := archivePath(dir, zf.Name)
+		if err != nil {
+			return err
+		}
+
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(path, 0755)
+		case mode&os.ModeSymlink != 0:
+			var target []byte
+			target, err = readZipFile(zf)
+			if err == nil {
+				err = symlink(dir, string(target), path)
+			}
+		default:
+			var rc io.ReadCloser
+			rc, err = zf.Open()
+			if err == nil {
+				err = writeFile(path, rc, mode)
+				rc.Close()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readZipFile returns the contents of a file in a zip archive.
+func readZipFile(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// extractTar extracts a tar archive read from r into dir. Entries other than
+// directories, files and links, such as devices, are skipped.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := archivePath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(path, tr, header.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = symlink(dir, header.Linkname, path)
+		case tar.TypeLink:
+			var target string
+			target, err = archivePath(dir, header.Linkname)
+			if err == nil {
+				err = os.Link(target, path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// archivePath returns where an entry of an archive is extracted to in dir. It
+// fails for entries that would end up outside of dir, either by their name,
+// such as ../../etc/passwd, or through a link extracted before them.
+func archivePath(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if !inside(dir, path) {
+		return "", fmt.Errorf("%s is outside of the archive", name)
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// The closest parent that was already extracted.
+	for parent := filepath.Dir(path); inside(dir, parent); parent = filepath.Dir(parent) {
+		realParent, err := filepath.EvalSymlinks(parent)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if !inside(realDir, realParent) {
+			return "", fmt.Errorf("%s is outside of the archive", name)
+		}
+		break
+	}
+
+	return path, nil
+}
+
+// inside reports whether path is dir or is in it.
+func inside(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// writeFile writes a file from an archive, creating its directory if the
+// archive has no entry for it.
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Replace a link of the same name rather than writing through it.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// symlink creates a symbolic link from an archive. The target must stay
+// inside dir, so that later entries can't be written through the link to
+// somewhere outside of it.
+func symlink(dir, target, path string) error {
+	if filepath.IsAbs(target) || !inside(dir, filepath.Join(filepath.Dir(path), target)) {
+		name, _ := filepath.Rel(dir, path)
+		return fmt.Errorf("%s links outside of the archive", name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.Symlink(target, path)
+}
@@ -0,0 +1,338 @@
+package fetcher
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is an entry of a tar archive built by a test.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+// makeTar builds a tar archive from entries.
+func makeTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	t.Helper()
+
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		header := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &b
+}
+
+// extractDir returns an empty directory to extract into, inside a parent
+// that also holds an outside file that no archive may touch.
+func extractDir(t *testing.T) (dir, outside string) {
+	t.Helper()
+
+	parent := t.TempDir()
+	outside = filepath.Join(parent, "outside")
+	if err := ioutil.WriteFile(outside, []byte("untouched"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir = filepath.Join(parent, "dir")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, outside
+}
+
+func TestArchivePath(t *testing.T) {
+	dir, _ := extractDir(t)
+
+	// A link to outside of dir, as if a malicious archive had placed it.
+	if err := os.Symlink("..", filepath.Join(dir, "up")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"file.txt", true},
+		{"sub/file.txt", true},
+		{"new/dir/file.txt", true},
+		{"sub/../file.txt", true},
+		{"/etc/passwd", true}, // Joined to dir, so it stays inside.
+		{"../file.txt", false},
+		{"sub/../../file.txt", false},
+		{"../dir/../outside", false},
+		{"up/outside", false},
+		{"up/new/file.txt", false},
+	}
+
+	for _, test := range tests {
+		path, err := archivePath(dir, test.name)
+		if test.ok && err != nil {
+			t.Errorf("archivePath(%q) failed: %s", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("archivePath(%q) = %s, expected an error", test.name, path)
+		}
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	tests := []struct {
+		path, target string
+		ok           bool
+	}{
+		{"link", "file.txt", true},
+		{"link", "sub/file.txt", true},
+		{"sub/link", "../file.txt", true},
+		{"sub/deeper/link", "../../file.txt", true},
+		{"link", ".", true},
+		{"link", "..", false},
+		{"link", "../outside", false},
+		{"sub/link", "../../outside", false},
+		{"link", "sub/../../outside", false},
+		{"link", "/etc/passwd", false},
+		{"link", "/", false},
+	}
+
+	for _, test := range tests {
+		dir, _ := extractDir(t)
+
+		err := symlink(dir, test.target, filepath.Join(dir, test.path))
+		if test.ok && err != nil {
+			t.Errorf("symlink %s -> %s failed: %s", test.path, test.target, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("symlink %s -> %s succeeded, expected an error", test.path, test.target)
+		}
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		ok      bool
+
+		// files are the contents expected in the extracted files.
+		files map[string]string
+	}{
+		{
+			name: "files and directories",
+			entries: []tarEntry{
+				{name: "repo/", typeflag: tar.TypeDir},
+				{name: "repo/a.txt", typeflag: tar.TypeReg, body: "a"},
+				{name: "repo/sub/b.txt", typeflag: tar.TypeReg, body: "b"},
+			},
+			ok:    true,
+			files: map[string]string{"repo/a.txt": "a", "repo/sub/b.txt": "b"},
+		},
+		{
+			name:    "parent directory in name",
+			entries: []tarEntry{{name: "../outside", typeflag: tar.TypeReg, body: "x"}},
+		},
+		{
+			name:    "parent directory inside name",
+			entries: []tarEntry{{name: "repo/../../outside", typeflag: tar.TypeReg, body: "x"}},
+		},
+		{
+			name:    "absolute symlink",
+			entries: []tarEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+		},
+		{
+			name:    "escaping symlink",
+			entries: []tarEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "../"}},
+		},
+		{
+			name: "file written through an escaping symlink",
+			entries: []tarEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "link/outside", typeflag: tar.TypeReg, body: "x"},
+			},
+		},
+		{
+			name: "file written through a symlink inside the archive",
+			entries: []tarEntry{
+				{name: "real/", typeflag: tar.TypeDir},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "real"},
+				{name: "link/a.txt", typeflag: tar.TypeReg, body: "a"},
+			},
+			ok:    true,
+			files: map[string]string{"real/a.txt": "a"},
+		},
+		{
+			name: "file replacing a symlink of the same name",
+			entries: []tarEntry{
+				{name: "target.txt", typeflag: tar.TypeReg, body: "target"},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "target.txt"},
+				{name: "link", typeflag: tar.TypeReg, body: "replaced"},
+			},
+			ok:    true,
+			files: map[string]string{"target.txt": "target", "link": "replaced"},
+		},
+		{
+			name: "hard link inside the archive",
+			entries: []tarEntry{
+				{name: "a.txt", typeflag: tar.TypeReg, body: "a"},
+				{name: "b.txt", typeflag: tar.TypeLink, linkname: "a.txt"},
+			},
+			ok:    true,
+			files: map[string]string{"a.txt": "a", "b.txt": "a"},
+		},
+		{
+			name:    "escaping hard link",
+			entries: []tarEntry{{name: "link", typeflag: tar.TypeLink, linkname: "../outside"}},
+		},
+		{
+			name: "hard link through an escaping symlink",
+			entries: []tarEntry{
+				{name: "up", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "link", typeflag: tar.TypeLink, linkname: "up/outside"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, outside := extractDir(t)
+
+			err := extractTar(makeTar(t, test.entries), dir)
+			if test.ok && err != nil {
+				t.Fatalf("extracting failed: %s", err)
+			}
+			if !test.ok && err == nil {
+				t.Fatal("extracting succeeded, expected an error")
+			}
+
+			for name, expected := range test.files {
+				b, err := ioutil.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Errorf("reading %s: %s", name, err)
+				} else if string(b) != expected {
+					t.Errorf("%s contains %q, expected %q", name, b, expected)
+				}
+			}
+
+			if b, err := ioutil.ReadFile(outside); err != nil || string(b) != "untouched" {
+				t.Errorf("the file outside of the archive was changed")
+			}
+		})
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		ok    bool
+	}{
+		{"files", []string{"repo/a.txt", "repo/sub/b.txt"}, true},
+		{"parent directory in name", []string{"../outside"}, false},
+		{"parent directory inside name", []string{"repo/../../outside"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, outside := extractDir(t)
+
+			archive := filepath.Join(t.TempDir(), "archive.zip")
+			f, err := os.Create(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw := zip.NewWriter(f)
+			for _, name := range test.files {
+				w, err := zw.Create(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				w.Write([]byte("x"))
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			err = extractZip(archive, dir)
+			if test.ok && err != nil {
+				t.Fatalf("extracting failed: %s", err)
+			}
+			if !test.ok && err == nil {
+				t.Fatal("extracting succeeded, expected an error")
+			}
+
+			if b, err := ioutil.ReadFile(outside); err != nil || string(b) != "untouched" {
+				t.Errorf("the file outside of the archive was changed")
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tarball := makeTar(t, []tarEntry{{name: "a.txt", typeflag: tar.TypeReg, body: "a"}})
+
+	tests := []struct {
+		name     string
+		contents []byte
+		format   string
+	}{
+		{"zip", []byte("PK\x03\x04rest"), FormatZip},
+		{"empty zip", []byte("PK\x05\x06rest"), FormatZip},
+		{"gzip", []byte("\x1f\x8brest"), FormatTarGz},
+		{"bzip2", []byte("BZh91AY&SY"), FormatTarBz},
+		{"xz", []byte("\xfd7zXZ\x00rest"), FormatTarXz},
+		{"zstd", []byte("\x28\xb5\x2f\xfdrest"), FormatTarZs},
+		{"tar", tarball.Bytes(), FormatTar},
+		{"html", []byte("<!DOCTYPE html><html><body>Not Found</body></html>"), ""},
+		{"short", []byte("P"), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), test.name)
+		if err := ioutil.WriteFile(filename, test.contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		format, err := detectFormat(filename)
+		if err != nil {
+			t.Errorf("detectFormat(%s) failed: %s", test.name, err)
+		} else if format != test.format {
+			t.Errorf("detectFormat(%s) = %q, expected %q", test.name, format, test.format)
+		}
+	}
+}
@@ -1,8 +1,90 @@
 package fetcher
 
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
 // Fetcher is an interface for types that fetch source code.
 type Fetcher interface {
 	GetSource() string
 	GetDestination() string
-	Fetch(baseDir string) error
+
+	// Fetch fetches the source code into baseDir. It stops and returns an
+	// error when ctx is cancelled or times out.
+	Fetch(ctx context.Context, baseDir string) error
+}
+
+// Pinner is implemented by fetchers that can report the exact identity of the
+// code they fetched (a commit, a revision or a checksum) and fetch that same
+// identity again later.
+type Pinner interface {
+	// Resolved returns the identity of the code retrieved by the last Fetch.
+	Resolved() string
+
+	// Pin makes the next Fetch retrieve exactly the given identity. Fetch
+	// fails with a PinError if it can not.
+	Pin(identity string)
+}
+
+// PinError is for when fetched code does not match its pinned identity.
+type PinError struct {
+	Source   string
+	Pinned   string
+	Resolved string
+}
+
+// Error returns the pin error message.
+func (e PinError) Error() string {
+	return fmt.Sprintf(
+		"%s resolved to %s but %s is pinned", e.Source, e.Resolved, e.Pinned,
+	)
+}
+
+// Placement is where, and at which identity, code was placed by an earlier
+// Fetch or Update.
+type Placement struct {
+	// Resolved is the identity the Pinner reported at the time.
+	Resolved string
+
+	// Paths are the absolute paths of the files and directories placed.
+	Paths []string
+}
+
+// Placer is implemented by fetchers that can report which files and
+// directories they placed, so they can be removed again later.
+type Placer interface {
+	// Paths returns the absolute paths placed by the last Fetch or Update.
+	Paths() []string
+}
+
+// Updater is implemented by fetchers that can bring code placed by an
+// earlier run up to date, instead of fetching it from scratch.
+type Updater interface {
+	// Update updates the code previously placed in baseDir. Like Fetch, it
+	// respects the pinned identity and stops when ctx is done.
+	Update(ctx context.Context, baseDir string, previous Placement) error
+}
+
+// Counter is implemented by fetchers that know how many bytes they
+// downloaded.
+type Counter interface {
+	// BytesFetched returns the number of bytes downloaded by the last Fetch.
+	BytesFetched() int64
+}
+
+// cleanup returns a function that removes path if *err is set and path did
+// not exist when cleanup was called. Defer it so that a failed or interrupted
+// fetch does not leave a half-written directory behind.
+func cleanup(path string, err *error) func() {
+	if _, statErr := os.Lstat(path); statErr == nil {
+		return func() {}
+	}
+
+	return func() {
+		if *err != nil {
+			os.RemoveAll(path)
+		}
+	}
 }
@@ -0,0 +1,253 @@
+package fetcher
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GitAuth is how a GitFetcher authenticates to the remote. Credentials are
+// handed to git through its environment for each command, so they never end
+// up in the .git/config of the checkout or on the command line.
+type GitAuth struct {
+	// SSHKey is the private key file to use for ssh remotes.
+	SSHKey string `yaml:"ssh_key"`
+
+	// Token is sent as the password of an HTTP Authorization header, with
+	// Username as the user.
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+
+	// Netrc is a .netrc file with the login and password for the remote's
+	// host, sent as an HTTP Authorization header.
+	Netrc string `yaml:"netrc"`
+
+	// CredentialHelper is a git credential helper to use, such as "store" or
+	// "osxkeychain".
+	CredentialHelper string `yaml:"credential_helper"`
+}
+
+// DefaultTokenUsername is the user sent with a token when no username is
+// given. GitHub accepts it for personal access tokens and app tokens.
+const DefaultTokenUsername = "x-access-token"
+
+// AuthError is for when the credentials for a remote can't be found.
+type AuthError struct {
+	Source string
+	msg    string
+}
+
+// Error returns the auth error message.
+func (e AuthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Source, e.msg)
+}
+
+// splitUserinfo removes the user and password from a URL, returning the
+// URL without them and the Userinfo, which is nil if there wasn't any.
+// Sources that aren't URLs, such as scp-like ssh remotes, are returned as is.
+func splitUserinfo(source string) (string, *url.Userinfo) {
+	u, err := url.Parse(source)
+	if err != nil || u.User == nil || u.Host == "" {
+		return source, nil
+	}
+
+	user := u.User
+	u.User = nil
+
+	return u.String(), user
+}
+
+// env returns the environment variables that make git authenticate to
+// source, which must not contain userinfo anymore. user is the userinfo that
+// was removed from the source, if any.
+func (a GitAuth) env(source string, user *url.Userinfo) ([]string, error) {
+	env := []string{
+		// Fail rather than hang waiting for a password nobody will type.
+		"GIT_TERMINAL_PROMPT=0",
+	}
+	var config []string
+
+	if a.SSHKey != "" {
+		key, err := filepath.Abs(a.SSHKey)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fmt.Sprintf(
+			"GIT_SSH_COMMAND=ssh -i '%s' -o IdentitiesOnly=yes",
+			strings.Replace(key, "'", `'\''`, -1),
+		))
+	}
+
+	u, err := url.Parse(source)
+	isHTTP := err == nil && (u.Scheme == "http" || u.Scheme == "https")
+
+	switch {
+	case !isHTTP:
+	case a.Token != "":
+		username := a.Username
+		if username == "" {
+			username = DefaultTokenUsername
+		}
+		user = url.UserPassword(username, a.Token)
+	case a.Netrc != "":
+		login, password, err := netrcLogin(a.Netrc, u.Hostname())
+		if err != nil {
+			return nil, AuthError{source, err.Error()}
+		}
+		user = url.UserPassword(login, password)
+	}
+
+	if isHTTP && user != nil {
+		// Only send the header to the remote's host, not wherever it
+		// redirects to.
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString(
+			[]byte(user.Username() + ":" + password),
+		)
+		config = append(config,
+			fmt.Sprintf("http.%s://%s/.extraHeader", u.Scheme, u.Host),
+			"Authorization: Basic "+credentials,
+		)
+	}
+
+	if a.CredentialHelper != "" {
+		config = append(config, "credential.helper", a.CredentialHelper)
+	}
+
+	// Configuration in the environment applies to a single command and is
+	// never written to a config file.
+	if len(config) > 0 {
+		if err := checkConfigEnv(); err != nil {
+			return nil, AuthError{source, err.Error()}
+		}
+
+		env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(config)/2))
+		for i := 0; i < len(config); i += 2 {
+			env = append(env,
+				fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i/2, config[i]),
+				fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i/2, config[i+1]),
+			)
+		}
+	}
+
+	return env, nil
+}
+
+// configEnvVersion is the first git version that reads configuration from
+// GIT_CONFIG_COUNT, which older versions silently ignore.
+var configEnvVersion = [2]int{2, 31}
+
+// gitVersionPattern matches the version in the output of git --version, such
+// as "git version 2.39.2 (Apple Git-143)".
+var gitVersionPattern = regexp.MustCompile(`git version (\d+)\.(\d+)`)
+
+// gitVersion returns the output of git --version. It only runs git once.
+var gitVersion = func() func() (string, error) {
+	var once sync.Once
+	var out []byte
+	var err error
+
+	return func() (string, error) {
+		once.Do(func() {
+			out, err = exec.Command("git", "--version").Output()
+		})
+		return string(out), err
+	}
+}()
+
+// checkConfigEnv returns an error if git is too old to read configuration,
+// such as credentials, from the environment. When the version of git can't be
+// told, git itself is left to fail.
+func checkConfigEnv() error {
+	out, err := gitVersion()
+	if err != nil {
+		return nil
+	}
+
+	m := gitVersionPattern.FindStringSubmatch(out)
+	if m == nil {
+		return nil
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+
+	if major < configEnvVersion[0] ||
+		major == configEnvVersion[0] && minor < configEnvVersion[1] {
+		return fmt.Errorf(
+			"git %d.%d or newer is needed to pass credentials, but this is git %d.%d",
+			configEnvVersion[0], configEnvVersion[1], major, minor,
+		)
+	}
+
+	return nil
+}
+
+// netrcLogin returns the login and password for a host from a .netrc file,
+// falling back to its default entry.
+func netrcLogin(filename, host string) (string, string, error) {
+	if strings.HasPrefix(filename, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", err
+		}
+		filename = filepath.Join(home, filename[2:])
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", "", err
+	}
+
+	type entry struct{ login, password string }
+	var found, fallback, current *entry
+
+	tokens := strings.Fields(string(b))
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if i+1 < len(tokens) {
+				i++
+				current = &entry{}
+				if tokens[i] == host && found == nil {
+					found = current
+				}
+			}
+		case "default":
+			current = &entry{}
+			if fallback == nil {
+				fallback = current
+			}
+		case "login", "password":
+			if current != nil && i+1 < len(tokens) {
+				if tokens[i] == "login" {
+					current.login = tokens[i+1]
+				} else {
+					current.password = tokens[i+1]
+				}
+			}
+			i++
+		case "account":
+			i++
+		case "macdef":
+			// Macros aren't supported, and nothing after them is read.
+			i = len(tokens)
+		}
+	}
+
+	if found == nil {
+		found = fallback
+	}
+	if found == nil {
+		return "", "", fmt.Errorf("no entry for %s in %s", host, filename)
+	}
+
+	return found.login, found.password, nil
+}
@@ -0,0 +1,209 @@
+package fetcher
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubGitVersion makes gitVersion report version for the rest of the test.
+func stubGitVersion(t *testing.T, version string) {
+	t.Helper()
+
+	original := gitVersion
+	gitVersion = func() (string, error) { return version, nil }
+	t.Cleanup(func() { gitVersion = original })
+}
+
+// basic returns the Authorization header for a user and password.
+func basic(user, password string) string {
+	return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestGitAuthEnv(t *testing.T) {
+	stubGitVersion(t, "git version 2.39.2 (Apple Git-143)\n")
+
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	err := ioutil.WriteFile(netrc, []byte("machine github.com login me password pw\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		auth   GitAuth
+		source string
+		user   *url.Userinfo
+
+		// env is the environment expected besides GIT_TERMINAL_PROMPT.
+		env []string
+	}{
+		{
+			name:   "nothing",
+			source: "https://github.com/org/repo.git",
+		},
+		{
+			name:   "token",
+			auth:   GitAuth{Token: "t0ken"},
+			source: "https://github.com/org/repo.git",
+			env: []string{
+				"GIT_CONFIG_COUNT=1",
+				"GIT_CONFIG_KEY_0=http.https://github.com/.extraHeader",
+				"GIT_CONFIG_VALUE_0=" + basic(DefaultTokenUsername, "t0ken"),
+			},
+		},
+		{
+			name:   "token with a username",
+			auth:   GitAuth{Token: "t0ken", Username: "me"},
+			source: "http://example.com:8080/repo.git",
+			env: []string{
+				"GIT_CONFIG_COUNT=1",
+				"GIT_CONFIG_KEY_0=http.http://example.com:8080/.extraHeader",
+				"GIT_CONFIG_VALUE_0=" + basic("me", "t0ken"),
+			},
+		},
+		{
+			name:   "userinfo from the URL",
+			source: "https://github.com/org/repo.git",
+			user:   url.UserPassword("TOKEN", "x-oauth-basic"),
+			env: []string{
+				"GIT_CONFIG_COUNT=1",
+				"GIT_CONFIG_KEY_0=http.https://github.com/.extraHeader",
+				"GIT_CONFIG_VALUE_0=" + basic("TOKEN", "x-oauth-basic"),
+			},
+		},
+		{
+			name:   "netrc",
+			auth:   GitAuth{Netrc: netrc},
+			source: "https://github.com/org/repo.git",
+			env: []string{
+				"GIT_CONFIG_COUNT=1",
+				"GIT_CONFIG_KEY_0=http.https://github.com/.extraHeader",
+				"GIT_CONFIG_VALUE_0=" + basic("me", "pw"),
+			},
+		},
+		{
+			name:   "token over ssh",
+			auth:   GitAuth{Token: "t0ken"},
+			source: "ssh://git@github.com/org/repo.git",
+		},
+		{
+			name:   "ssh key",
+			auth:   GitAuth{SSHKey: "/keys/it's"},
+			source: "git@github.com:org/repo.git",
+			env:    []string{`GIT_SSH_COMMAND=ssh -i '/keys/it'\''s' -o IdentitiesOnly=yes`},
+		},
+		{
+			name:   "credential helper",
+			auth:   GitAuth{CredentialHelper: "store"},
+			source: "https://github.com/org/repo.git",
+			env: []string{
+				"GIT_CONFIG_COUNT=1",
+				"GIT_CONFIG_KEY_0=credential.helper",
+				"GIT_CONFIG_VALUE_0=store",
+			},
+		},
+		{
+			name:   "token and credential helper",
+			auth:   GitAuth{Token: "t0ken", CredentialHelper: "store"},
+			source: "https://github.com/org/repo.git",
+			env: []string{
+				"GIT_CONFIG_COUNT=2",
+				"GIT_CONFIG_KEY_0=http.https://github.com/.extraHeader",
+				"GIT_CONFIG_VALUE_0=" + basic(DefaultTokenUsername, "t0ken"),
+				"GIT_CONFIG_KEY_1=credential.helper",
+				"GIT_CONFIG_VALUE_1=store",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env, err := test.auth.env(test.source, test.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := append([]string{"GIT_TERMINAL_PROMPT=0"}, test.env...)
+			if !reflect.DeepEqual(env, expected) {
+				t.Errorf("got environment\n%q\nexpected\n%q", env, expected)
+			}
+		})
+	}
+}
+
+func TestGitAuthEnvErrors(t *testing.T) {
+	source := "https://github.com/org/repo.git"
+
+	stubGitVersion(t, "git version 2.25.1\n")
+	_, err := GitAuth{Token: "t0ken"}.env(source, nil)
+	if !errors.As(err, &AuthError{}) || !strings.Contains(err.Error(), "git 2.31 or newer") {
+		t.Errorf("got error %v, expected one about the git version", err)
+	}
+
+	// Without credentials to pass, an old git is fine.
+	if _, err := (GitAuth{}).env(source, nil); err != nil {
+		t.Errorf("got error %v without credentials", err)
+	}
+
+	stubGitVersion(t, "git version 2.31.0\n")
+	if _, err := (GitAuth{Token: "t0ken"}).env(source, nil); err != nil {
+		t.Errorf("got error %v with git 2.31", err)
+	}
+
+	_, err = GitAuth{Netrc: filepath.Join(t.TempDir(), "missing")}.env(source, nil)
+	if !errors.As(err, &AuthError{}) {
+		t.Errorf("got error %v, expected an AuthError for a missing netrc", err)
+	}
+}
+
+func TestNetrcLogin(t *testing.T) {
+	netrc := `
+machine example.com
+  login first
+  password one
+machine github.com login me password pw account ignored
+machine github.com login second password two
+default login anonymous password guest
+macdef init
+  machine after.macdef login no password no
+`
+
+	filename := filepath.Join(t.TempDir(), "netrc")
+	if err := ioutil.WriteFile(filename, []byte(netrc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host, login, password string
+	}{
+		{"example.com", "first", "one"},
+		{"github.com", "me", "pw"},
+		{"other.com", "anonymous", "guest"},
+		{"after.macdef", "anonymous", "guest"},
+	}
+
+	for _, test := range tests {
+		login, password, err := netrcLogin(filename, test.host)
+		if err != nil {
+			t.Errorf("netrcLogin(%s) failed: %s", test.host, err)
+		} else if login != test.login || password != test.password {
+			t.Errorf("netrcLogin(%s) = %s, %s, expected %s, %s",
+				test.host, login, password, test.login, test.password)
+		}
+	}
+
+	// Without a default entry, unknown hosts have no login.
+	noDefault := filepath.Join(t.TempDir(), "netrc")
+	if err := ioutil.WriteFile(noDefault, []byte("machine a login b password c"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := netrcLogin(noDefault, "other.com"); err == nil {
+		t.Error("netrcLogin found a login for a host that isn't there")
+	}
+}
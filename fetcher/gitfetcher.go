@@ -1,20 +1,75 @@
 package fetcher
 
 import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
 	"path/filepath"
-	"time"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GitOptions are the optional settings of a GitFetcher.
+type GitOptions struct {
+	// Auth is how to authenticate to the remote.
+	Auth GitAuth
+
+	// Depth limits the history that is cloned to that many commits. Zero
+	// clones the whole history.
+	Depth int
+
+	// SingleBranch only clones the branch or tag in version.
+	SingleBranch bool
+
+	// Submodules is which submodules to check out along with the project.
+	Submodules Submodules
+
+	// LFS downloads the files stored in Git LFS instead of leaving pointer
+	// files in their place.
+	LFS bool
+
+	// Export places a plain tree without the .git directory.
+	Export bool
+
+	// Subtree places only part of the repository. The result can't be a
+	// working copy, so it is placed like an export.
+	Subtree
+}
+
+// Submodules is which submodules of a repository to check out.
+type Submodules int
 
-	"github.com/gogits/git-module"
+// These are the submodules that can be checked out.
+const (
+	NoSubmodules        Submodules = iota // Leave submodules empty.
+	TopSubmodules                         // The repository's own submodules.
+	RecursiveSubmodules                   // Submodules of submodules too.
 )
 
+// commitPattern matches a full commit SHA, which unlike a short one can be
+// fetched by itself.
+var commitPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// shortCommitPattern matches what may be an abbreviated commit SHA. It can
+// also be a branch or tag name, so it is only treated as a commit once the
+// remote has no such branch or tag.
+var shortCommitPattern = regexp.MustCompile(`^[0-9a-f]{4,39}$`)
+
 // NewGitFetcher gets a new GitFetcher.
-func NewGitFetcher(source, destination, rename, version string) *GitFetcher {
+func NewGitFetcher(source, destination, rename, version string, options GitOptions) *GitFetcher {
 	gf := new(GitFetcher)
 
 	gf.source = source
 	gf.destination = destination
 	gf.rename = rename
 	gf.version = version
+	gf.options = options
+
+	// Credentials in the URL are sent the same way as those in an auth
+	// block, so they aren't saved in the checkout's .git/config.
+	gf.remote, gf.user = splitUserinfo(source)
 
 	return gf
 }
@@ -22,6 +77,24 @@ func NewGitFetcher(source, destination, rename, version string) *GitFetcher {
 // GitFetcher fetches source code from git.
 type GitFetcher struct {
 	rename, source, destination, version string
+	options                              GitOptions
+
+	// remote is the source without any user and password, which are in user.
+	remote string
+	user   *url.Userinfo
+
+	// pinned is the commit to check out instead of version, resolved is the
+	// commit that was checked out.
+	pinned, resolved string
+
+	// paths are where the repository was checked out, or where the files
+	// of an export were placed.
+	paths []string
+
+	cache *Cache
+
+	// redact masks secrets in the source recorded by an export.
+	redact func(string) string
 }
 
 // GetSource returns the location of the source code and is required by the
@@ -36,21 +109,373 @@ func (gf *GitFetcher) GetDestination() string {
 	return gf.destination
 }
 
+// Resolved returns the commit SHA that was checked out and is required by the
+// Pinner interface.
+func (gf *GitFetcher) Resolved() string {
+	return gf.resolved
+}
+
+// Pin sets the commit SHA to check out and is required by the Pinner
+// interface.
+func (gf *GitFetcher) Pin(identity string) {
+	gf.pinned = identity
+}
+
+// SetCache sets the cache to keep a mirror of the repository in and is
+// required by the Cacher interface.
+func (gf *GitFetcher) SetCache(cache *Cache) {
+	gf.cache = cache
+}
+
+// Paths returns the checkout and is required by the Placer interface.
+func (gf *GitFetcher) Paths() []string {
+	return gf.paths
+}
+
+// SetRedact sets the function that masks secrets in the source recorded by
+// an export and is required by the Redacter interface.
+func (gf *GitFetcher) SetRedact(redact func(string) string) {
+	gf.redact = redact
+}
+
+// Export makes the fetcher place a plain tree without the .git directory and
+// is required by the Exporter interface.
+func (gf *GitFetcher) Export() {
+	gf.options.Export = true
+}
+
+// git runs git in dir with the credentials for the remote.
+func (gf *GitFetcher) git(ctx context.Context, dir string, args ...string) (string, error) {
+	env, err := gf.options.Auth.env(gf.remote, gf.user)
+	if err != nil {
+		return "", err
+	}
+
+	return runEnv(ctx, dir, env, "git", args...)
+}
+
+// mirror returns the path to the cached mirror of the remote.
+func (gf *GitFetcher) mirror(ctx context.Context) (string, error) {
+	env, err := gf.options.Auth.env(gf.remote, gf.user)
+	if err != nil {
+		return "", err
+	}
+
+	return gf.cache.gitMirror(ctx, gf.remote, env)
+}
+
 // Fetch fetches the source code and is required by the Fetcher interface.
-func (gf *GitFetcher) Fetch(baseDir string) error {
+func (gf *GitFetcher) Fetch(ctx context.Context, baseDir string) (err error) {
 	dest := filepath.Join(baseDir, gf.destination, gf.rename)
-	cro := git.CloneRepoOptions{Timeout: time.Minute * 5}
-	err := git.Clone(gf.source, dest, cro)
+	defer cleanup(dest, &err)()
+	gf.paths = []string{dest}
+
+	if gf.options.Export || gf.options.selects() {
+		gf.paths, err = exportTree(dest, ".git", gf.options.Subtree, gf.redact, func(dir string) (ExportInfo, error) {
+			err := gf.get(ctx, dir)
+			return ExportInfo{"git", gf.remote, gf.version, gf.resolved}, err
+		})
+		return err
+	}
+
+	return gf.get(ctx, dest)
+}
+
+// get clones the repository into dest and checks out the version.
+func (gf *GitFetcher) get(ctx context.Context, dest string) error {
+	// With a cache, clone from an up to date local mirror instead of the
+	// remote, then point origin back at the remote.
+	if gf.mirrored() {
+		mirror, err := gf.mirror(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = gf.git(ctx, "", "clone", mirror, dest)
+		if err != nil {
+			return err
+		}
+
+		_, err = gf.git(ctx, dest, "remote", "set-url", "origin", gf.remote)
+		if err != nil {
+			return err
+		}
+	} else if err := gf.clone(ctx, dest); err != nil {
+		return err
+	}
+
+	return gf.checkout(ctx, dest)
+}
+
+// mirrored reports whether the repository is fetched through the cache's
+// mirror. The mirror has the whole history, so a shallow or single branch
+// clone, or a version that is a commit to fetch by itself, goes to the remote
+// instead, unless working offline. A commit pinned by the lockfile still uses
+// the mirror, or locking would turn the cache off.
+func (gf *GitFetcher) mirrored() bool {
+	if gf.cache == nil {
+		return false
+	}
+
+	limited := gf.options.Depth > 0 || gf.options.SingleBranch ||
+		commitPattern.MatchString(gf.version)
+
+	return !limited || gf.cache.Offline
+}
+
+// target returns what to check out: the pinned commit, or the version.
+func (gf *GitFetcher) target() string {
+	if gf.pinned != "" {
+		return gf.pinned
+	}
+
+	return gf.version
+}
+
+// depth returns the --depth argument to fetch a single commit with.
+func (gf *GitFetcher) depth() string {
+	if gf.options.Depth > 0 {
+		return strconv.Itoa(gf.options.Depth)
+	}
+
+	return "1"
+}
+
+// clone clones the remote into dest with as little history as the options
+// allow. A commit is fetched by itself, unless the server refuses to, in
+// which case the whole repository is cloned.
+func (gf *GitFetcher) clone(ctx context.Context, dest string) error {
+	version := gf.target()
+
+	if commitPattern.MatchString(version) {
+		err := gf.fetchCommit(ctx, dest, version)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+
+		_, err = gf.git(ctx, "", "clone", gf.remote, dest)
+		return err
+	}
+
+	limited := gf.options.Depth > 0 || gf.options.SingleBranch
+
+	args := []string{"clone"}
+	if gf.options.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(gf.options.Depth))
+	}
+	if gf.options.SingleBranch {
+		args = append(args, "--single-branch")
+	}
+	if limited && version != "" {
+		args = append(args, "--branch", strings.TrimPrefix(version, "tags/"))
+	}
+
+	_, err := gf.git(ctx, "", append(args, gf.remote, dest)...)
+
+	// An abbreviated commit can't be cloned as a branch, nor fetched by
+	// itself, so the whole repository is cloned to find it.
+	if err != nil && ctx.Err() == nil && limited && shortCommitPattern.MatchString(version) {
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+
+		_, err = gf.git(ctx, "", "clone", gf.remote, dest)
+	}
+
+	return err
+}
+
+// fetchCommit creates a repository in dest with only the given commit, and
+// as much history as the depth allows, fetched from the remote. Servers that
+// don't allow fetching a commit that isn't a branch or tag refuse this.
+func (gf *GitFetcher) fetchCommit(ctx context.Context, dest, commit string) error {
+	_, err := gf.git(ctx, "", "init", "--quiet", dest)
+	if err != nil {
+		return err
+	}
+
+	_, err = gf.git(ctx, dest, "remote", "add", "origin", gf.remote)
+	if err != nil {
+		return err
+	}
+
+	_, err = gf.git(ctx, dest, "fetch", "--depth", gf.depth(), "origin", commit)
+	return err
+}
+
+// Update fetches new commits into an existing checkout and checks out the
+// version again. Local changes, such as applied patches, are discarded. It is
+// required by the Updater interface.
+func (gf *GitFetcher) Update(ctx context.Context, baseDir string, previous Placement) error {
+	dest := filepath.Join(baseDir, gf.destination, gf.rename)
+	gf.paths = []string{dest}
+
+	// An export has no history to update, so it is placed again. The
+	// same goes for a project that was exported before, but no longer is.
+	_, statErr := os.Stat(filepath.Join(dest, ExportFilename))
+	if gf.options.Export || gf.options.selects() || statErr == nil {
+		for _, path := range previous.Paths {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+
+		return gf.Fetch(ctx, baseDir)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, ".git")); err != nil {
+		return fmt.Errorf("%s is not a git checkout and can't be updated", dest)
+	}
+
+	// Checkouts from before credentials were kept out of the URL still have
+	// them in their origin.
+	_, err := gf.git(ctx, dest, "remote", "set-url", "origin", gf.remote)
+	if err != nil {
+		return err
+	}
+
+	if gf.mirrored() {
+		mirror, err := gf.mirror(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = gf.git(ctx, dest, "fetch", "--prune", "--tags",
+			mirror, "+refs/heads/*:refs/remotes/origin/*")
+		if err != nil {
+			return err
+		}
+	} else if err = gf.fetch(ctx, dest); err != nil {
+		return err
+	}
+
+	return gf.checkout(ctx, dest)
+}
+
+// fetch fetches new commits from the remote into an existing checkout, with
+// no more history than the options allow.
+func (gf *GitFetcher) fetch(ctx context.Context, dest string) error {
+	version := gf.target()
+
+	if commitPattern.MatchString(version) {
+		_, err := gf.git(ctx, dest, "fetch", "--depth", gf.depth(), "origin", version)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	args := []string{"fetch", "--prune", "--tags"}
+	if gf.options.Depth > 0 && !commitPattern.MatchString(version) {
+		args = append(args, "--depth", strconv.Itoa(gf.options.Depth))
+	}
+
+	_, err := gf.git(ctx, dest, append(args, "origin")...)
+	if err != nil || !shortCommitPattern.MatchString(version) {
+		return err
+	}
+
+	// An abbreviated commit that isn't a branch or tag may be older than
+	// the depth, or on a branch a single branch checkout doesn't fetch.
+	_, err = gf.git(ctx, dest, "rev-parse", "--verify", "--quiet", version+"^{commit}")
+	if err == nil {
+		return nil
+	}
+
+	args = []string{"fetch", "--prune", "--tags"}
+	if _, err := os.Stat(filepath.Join(dest, ".git", "shallow")); err == nil {
+		args = append(args, "--unshallow")
+	}
+
+	_, err = gf.git(ctx, dest, append(args, "origin", "+refs/heads/*:refs/remotes/origin/*")...)
+	return err
+}
+
+// checkout checks out the version, or the pinned commit, and records the
+// commit that was checked out.
+func (gf *GitFetcher) checkout(ctx context.Context, dest string) error {
+	version := gf.target()
+
+	// Reset a branch to the remote, so an update picks up new commits.
+	args := []string{"checkout", "-f", version}
+	_, err := gf.git(ctx, dest, "rev-parse", "--verify", "--quiet",
+		"refs/remotes/origin/"+version)
+	if err == nil {
+		args = []string{"checkout", "-f", "-B", version, "origin/" + version}
+	}
+
+	_, err = gf.git(ctx, dest, args...)
 	if err != nil {
 		return err
 	}
 
-	// Hopefully support for "checkout" will be added nativly:
-	// https://github.com/gogits/git-module/pull/11
-	_, err = git.NewCommand("checkout", gf.version).RunInDir(dest)
+	if err := gf.submodules(ctx, dest); err != nil {
+		return err
+	}
+
+	if err := gf.lfs(ctx, dest); err != nil {
+		return err
+	}
+
+	head, err := gf.git(ctx, dest, "rev-parse", "HEAD")
 	if err != nil {
-		panic(err)
+		return err
+	}
+	gf.resolved = strings.TrimSpace(head)
+
+	if gf.pinned != "" && gf.pinned != gf.resolved {
+		return PinError{gf.source, gf.pinned, gf.resolved}
 	}
 
 	return nil
 }
+
+// submodules checks out the submodules of the commit that was checked out,
+// discarding any local changes to them.
+func (gf *GitFetcher) submodules(ctx context.Context, dest string) error {
+	if gf.options.Submodules == NoSubmodules {
+		return nil
+	}
+
+	var recursive []string
+	if gf.options.Submodules == RecursiveSubmodules {
+		recursive = []string{"--recursive"}
+	}
+
+	// Pick up submodules whose URL changed since the last checkout.
+	_, err := gf.git(ctx, dest, append([]string{"submodule", "sync"}, recursive...)...)
+	if err != nil {
+		return err
+	}
+
+	_, err = gf.git(ctx, dest,
+		append([]string{"submodule", "update", "--init", "--force"}, recursive...)...)
+	return err
+}
+
+// lfs downloads the Git LFS files of the commit that was checked out, and of
+// its submodules.
+func (gf *GitFetcher) lfs(ctx context.Context, dest string) error {
+	if !gf.options.LFS {
+		return nil
+	}
+
+	_, err := gf.git(ctx, dest, "lfs", "pull")
+	if err != nil {
+		return err
+	}
+
+	if gf.options.Submodules == NoSubmodules {
+		return nil
+	}
+
+	args := []string{"submodule", "foreach", "--quiet"}
+	if gf.options.Submodules == RecursiveSubmodules {
+		args = append(args, "--recursive")
+	}
+	_, err = gf.git(ctx, dest, append(args, "git lfs pull")...)
+	return err
+}
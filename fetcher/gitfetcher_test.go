@@ -0,0 +1,101 @@
+package fetcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitRepo creates a repository with a commit for each of contents, which are
+// written to file.txt, and returns its file URL and the commits.
+func gitRepo(t *testing.T, contents ...string) (string, []string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=tasc", "GIT_AUTHOR_EMAIL=tasc@example.com",
+			"GIT_COMMITTER_NAME=tasc", "GIT_COMMITTER_EMAIL=tasc@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	git("init", "--quiet", "--initial-branch", "main")
+	var commits []string
+	for _, c := range contents {
+		if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "file.txt")
+		git("commit", "--quiet", "-m", c)
+		commits = append(commits, git("rev-parse", "HEAD"))
+	}
+
+	// A branch other than main, for single branch clones.
+	git("checkout", "--quiet", "-b", "other", commits[0])
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "--quiet", "-am", "other")
+	commits = append(commits, git("rev-parse", "HEAD"))
+	git("checkout", "--quiet", "main")
+
+	return "file://" + dir, commits
+}
+
+func TestGitFetcherShortCommit(t *testing.T) {
+	source, commits := gitRepo(t, "one", "two", "three")
+
+	tests := []struct {
+		name    string
+		options GitOptions
+		commit  string
+	}{
+		{"depth", GitOptions{Depth: 1}, commits[0]},
+		{"single branch", GitOptions{SingleBranch: true}, commits[3]},
+		{"depth and single branch", GitOptions{Depth: 1, SingleBranch: true}, commits[1]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+
+			gf := NewGitFetcher(source, "", "repo", test.commit[:7], test.options)
+			if err := gf.Fetch(context.Background(), baseDir); err != nil {
+				t.Fatal(err)
+			}
+			if gf.Resolved() != test.commit {
+				t.Errorf("checked out %s, expected %s", gf.Resolved(), test.commit)
+			}
+
+			// An update to another abbreviated commit finds it too.
+			other := commits[2]
+			if test.commit == other {
+				other = commits[0]
+			}
+			gf = NewGitFetcher(source, "", "repo", other[:7], test.options)
+			err := gf.Update(context.Background(), baseDir, Placement{Paths: gf.Paths()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gf.Resolved() != other {
+				t.Errorf("updated to %s, expected %s", gf.Resolved(), other)
+			}
+		})
+	}
+}
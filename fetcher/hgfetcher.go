@@ -0,0 +1,187 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NewHgFetcher gets a new HgFetcher.
+func NewHgFetcher(source, destination, rename, version string) *HgFetcher {
+	hf := new(HgFetcher)
+
+	hf.source = source
+	hf.destination = destination
+	hf.rename = rename
+	hf.version = version
+
+	// Credentials in the URL are passed to hg as auth settings instead, so
+	// they aren't saved in the clone's .hg/hgrc. Only http(s) remotes have
+	// auth settings; the user of an ssh remote stays where it is.
+	hf.remote = source
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		hf.remote, hf.user = splitUserinfo(source)
+	}
+
+	return hf
+}
+
+// HgFetcher fetches source code from Mercurial.
+type HgFetcher struct {
+	rename, source, destination, version string
+
+	// remote is the source without any user and password, which are in user.
+	remote string
+	user   *url.Userinfo
+
+	// pinned is the changeset to check out, resolved is the changeset that
+	// was checked out.
+	pinned, resolved string
+
+	// paths are where the repository was cloned.
+	paths []string
+
+	cache *Cache
+}
+
+// GetSource returns the location of the source code and is required by the
+// Fetcher interface.
+func (hf *HgFetcher) GetSource() string {
+	return hf.source
+}
+
+// GetDestination returns the location of the destination and is required by the
+// Fetcher interface.
+func (hf *HgFetcher) GetDestination() string {
+	return hf.destination
+}
+
+// Resolved returns the changeset that was checked out and is required by the
+// Pinner interface.
+func (hf *HgFetcher) Resolved() string {
+	return hf.resolved
+}
+
+// Pin sets the changeset to check out and is required by the Pinner
+// interface.
+func (hf *HgFetcher) Pin(identity string) {
+	hf.pinned = identity
+}
+
+// SetCache is required by the Cacher interface. Mercurial clones are not
+// cached, but an offline cache means they can't be fetched at all.
+func (hf *HgFetcher) SetCache(cache *Cache) {
+	hf.cache = cache
+}
+
+// Paths returns the clone and is required by the Placer interface.
+func (hf *HgFetcher) Paths() []string {
+	return hf.paths
+}
+
+// Fetch fetches the source code and is required by the Fetcher interface.
+func (hf *HgFetcher) Fetch(ctx context.Context, baseDir string) (err error) {
+	if hf.cache != nil && hf.cache.Offline {
+		return OfflineError{hf.source}
+	}
+
+	dest := filepath.Join(baseDir, hf.destination, hf.rename)
+	defer cleanup(dest, &err)()
+	hf.paths = []string{dest}
+
+	// Clone without a working directory, since the version is checked out
+	// next anyway.
+	_, err = hf.hg(ctx, "clone", "--noupdate", hf.remote, dest)
+	if err != nil {
+		return err
+	}
+
+	return hf.checkout(ctx, dest)
+}
+
+// Update pulls into an existing clone and checks out the version. Local
+// changes, such as applied patches, are discarded. It is required by the
+// Updater interface.
+func (hf *HgFetcher) Update(ctx context.Context, baseDir string, previous Placement) error {
+	if hf.cache != nil && hf.cache.Offline {
+		return OfflineError{hf.source}
+	}
+
+	dest := filepath.Join(baseDir, hf.destination, hf.rename)
+	hf.paths = []string{dest}
+
+	if _, err := os.Stat(filepath.Join(dest, ".hg")); err != nil {
+		return fmt.Errorf("%s is not a Mercurial clone and can't be updated", dest)
+	}
+
+	_, err := hf.hg(ctx, "pull", "-R", dest, hf.remote)
+	if err != nil {
+		return err
+	}
+
+	return hf.checkout(ctx, dest)
+}
+
+// checkout updates the working directory of the clone in dest to the pinned
+// changeset or the version, which may be a changeset, tag, branch or
+// bookmark. Without either, the tip of the default branch is checked out.
+func (hf *HgFetcher) checkout(ctx context.Context, dest string) error {
+	revision := hf.version
+	if hf.pinned != "" {
+		revision = hf.pinned
+	}
+	if revision == "" {
+		revision = "default"
+	}
+
+	_, err := hf.hg(ctx, "update", "-R", dest, "--clean", "-r", revision)
+	if err != nil {
+		return err
+	}
+
+	out, err := hf.hg(ctx, "log", "-R", dest, "-r", ".", "--template", "{node}")
+	if err != nil {
+		return err
+	}
+	hf.resolved = strings.TrimSpace(out)
+
+	if hf.pinned != "" && hf.pinned != hf.resolved {
+		return PinError{hf.source, hf.pinned, hf.resolved}
+	}
+
+	return nil
+}
+
+// hg runs an hg subcommand with the credentials, without prompting for
+// anything, and with output that isn't changed by the user's configuration.
+func (hf *HgFetcher) hg(ctx context.Context, args ...string) (string, error) {
+	args = append(args, "--noninteractive")
+	args = append(args, hf.authConfig()...)
+
+	return runEnv(ctx, "", []string{"HGPLAIN=1"}, "hg", args...)
+}
+
+// authConfig returns the --config options that give hg the user and password
+// from the source for the remote. The options go after the subcommand, so
+// they are never part of a CommandError.
+func (hf *HgFetcher) authConfig() []string {
+	if hf.user == nil {
+		return nil
+	}
+
+	config := []string{"auth.tasc.prefix=" + hf.remote}
+	config = append(config, "auth.tasc.username="+hf.user.Username())
+	if password, ok := hf.user.Password(); ok {
+		config = append(config, "auth.tasc.password="+password)
+	}
+
+	var args []string
+	for _, c := range config {
+		args = append(args, "--config", c)
+	}
+
+	return args
+}
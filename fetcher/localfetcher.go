@@ -1,22 +1,36 @@
 package fetcher
 
 import (
+	"context"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 )
 
+// LocalOptions are the optional settings of a LocalFetcher.
+type LocalOptions struct {
+	// Subtree copies only part of a source directory.
+	Subtree
+}
+
 // LocalFetcher fetches local files
 type LocalFetcher struct {
 	source, destination string
+	options             LocalOptions
+
+	// pinned is the checksum the source must have, resolved is the checksum
+	// of the source that was copied.
+	pinned, resolved string
 }
 
 // NewLocalFetcher gets a new LocalFetcher
-func NewLocalFetcher(source, destination string) *LocalFetcher {
+func NewLocalFetcher(source, destination string, options LocalOptions) *LocalFetcher {
 	lf := new(LocalFetcher)
 
 	lf.source = source
 	lf.destination = destination
+	lf.options = options
 
 	return lf
 }
@@ -33,8 +47,65 @@ func (lf *LocalFetcher) GetDestination() string {
 	return lf.destination
 }
 
+// Resolved returns the sha256 checksum of the copied source and is required
+// by the Pinner interface.
+func (lf *LocalFetcher) Resolved() string {
+	return lf.resolved
+}
+
+// Pin sets the checksum the source must have and is required by the Pinner
+// interface.
+func (lf *LocalFetcher) Pin(identity string) {
+	lf.pinned = identity
+}
+
+// Paths returns the destination and is required by the Placer interface.
+func (lf *LocalFetcher) Paths() []string {
+	path, err := filepath.Abs(lf.destination)
+	if err != nil {
+		return []string{lf.destination}
+	}
+
+	return []string{path}
+}
+
+// Update copies the source again if it has changed since it was last copied,
+// replacing the previous copy. It is required by the Updater interface.
+func (lf *LocalFetcher) Update(ctx context.Context, baseDir string, previous Placement) error {
+	resolved, err := hashTree(lf.source)
+	if err != nil {
+		return err
+	}
+
+	if lf.pinned != "" && lf.pinned != resolved {
+		return PinError{lf.source, lf.pinned, resolved}
+	}
+
+	if resolved == previous.Resolved && exists(previous.Paths) {
+		lf.resolved = resolved
+		return nil
+	}
+
+	for _, path := range previous.Paths {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	return lf.Fetch(ctx, baseDir)
+}
+
 // Fetch fetches the source code and is required by the Fetcher interface.
-func (lf *LocalFetcher) Fetch(baseDir string) error {
+func (lf *LocalFetcher) Fetch(ctx context.Context, baseDir string) (err error) {
+	lf.resolved, err = hashTree(lf.source)
+	if err != nil {
+		return err
+	}
+
+	if lf.pinned != "" && lf.pinned != lf.resolved {
+		return PinError{lf.source, lf.pinned, lf.resolved}
+	}
+
 	s, err := os.Open(lf.source)
 	if err != nil {
 		return err
@@ -46,24 +117,61 @@ func (lf *LocalFetcher) Fetch(baseDir string) error {
 		return err
 	}
 
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	defer cleanup(lf.destination, &err)()
+
 	switch mode := info.Mode(); {
+	case mode.IsDir() && lf.options.selects():
+		err = lf.copySubtree()
 	case mode.IsDir():
 		err = os.MkdirAll(lf.destination, 0755)
 		if err != nil {
 			return err
 		}
 
-		CopyDir(lf.source, lf.destination)
+		err = CopyDir(lf.source, lf.destination)
+	case lf.options.selects():
+		err = SubtreeError{lf.source, "only part of a directory can be copied"}
 	case mode.IsRegular():
 		err = os.MkdirAll(filepath.Dir(lf.destination), 0755)
 		if err != nil {
 			return err
 		}
 
-		CopyFile(lf.source, lf.destination)
+		err = CopyFile(lf.source, lf.destination)
+	}
+
+	return err
+}
+
+// copySubtree copies the subtree of the source directory. The whole source is
+// copied to a temporary directory first, since the subtree is selected by
+// moving files around.
+func (lf *LocalFetcher) copySubtree() error {
+	err := os.MkdirAll(lf.destination, 0755)
+	if err != nil {
+		return err
+	}
+
+	tempDir, err := ioutil.TempDir(lf.destination, ".tasc-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	err = CopyDir(lf.source, tempDir)
+	if err != nil {
+		return err
+	}
+
+	root, err := lf.options.root(lf.source, tempDir)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return CopyDir(root, lf.destination)
 }
 
 // CopyFile copys a file.
@@ -0,0 +1,169 @@
+package fetcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Subtree selects the part of a fetched tree that is placed, for example to
+// drop the top-level directory archives of releases usually have.
+type Subtree struct {
+	// StripComponents removes that many leading directories from every
+	// path, like tar --strip-components. Files in those directories are
+	// dropped.
+	StripComponents int
+
+	// Subpath is the directory, after stripping, whose contents are placed.
+	Subpath string
+}
+
+// SubtreeError is for when the selected part of a tree doesn't exist.
+type SubtreeError struct {
+	Source string
+	msg    string
+}
+
+// Error returns the subtree error message.
+func (e SubtreeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Source, e.msg)
+}
+
+// selects reports whether only part of the tree is placed.
+func (s Subtree) selects() bool {
+	return s.StripComponents > 0 || s.Subpath != ""
+}
+
+// root selects the part of the tree in dir that is placed, and returns the
+// directory that holds it. Stripped entries are moved within dir, so dir
+// must be a temporary copy.
+func (s Subtree) root(source, dir string) (string, error) {
+	root := dir
+
+	if s.StripComponents > 0 {
+		stripped, err := ioutil.TempDir(dir, ".tasc-")
+		if err != nil {
+			return "", err
+		}
+
+		if err := strip(dir, stripped, s.StripComponents, stripped); err != nil {
+			return "", err
+		}
+		root = stripped
+	}
+
+	if s.Subpath != "" {
+		root = filepath.Join(root, s.Subpath)
+
+		fi, err := os.Stat(root)
+		if err != nil || !fi.IsDir() || !inside(dir, root) {
+			return "", SubtreeError{source, fmt.Sprintf(
+				"subpath %s is not a directory", s.Subpath,
+			)}
+		}
+
+		// The subpath may go through a link in the tree, which must not lead
+		// outside of it, or files would be moved out of wherever it points.
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return "", err
+		}
+		realRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return "", err
+		}
+		if !inside(realDir, realRoot) {
+			return "", SubtreeError{source, fmt.Sprintf(
+				"subpath %s links outside of the project", s.Subpath,
+			)}
+		}
+	}
+
+	objects, err := ioutil.ReadDir(root)
+	if err != nil {
+		return "", err
+	}
+	if len(objects) == 0 && s.Subpath != "" {
+		return "", SubtreeError{source, fmt.Sprintf("subpath %s is empty", s.Subpath)}
+	}
+	if len(objects) == 0 {
+		return "", SubtreeError{source, fmt.Sprintf(
+			"nothing is left to place after stripping %d components",
+			s.StripComponents,
+		)}
+	}
+
+	return root, nil
+}
+
+// strip moves the entries n directories below dir into to, merging
+// directories of the same name. skip is left alone.
+func strip(dir, to string, n int, skip string) error {
+	objects, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		path := filepath.Join(dir, obj.Name())
+		if path == skip {
+			continue
+		}
+
+		switch {
+		case n == 0:
+			if _, err := merge(path, filepath.Join(to, obj.Name())); err != nil {
+				return err
+			}
+		case obj.IsDir():
+			if err := strip(path, to, n-1, skip); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// merge moves path to target. Directories are merged with an existing
+// directory at target, anything else replaces what is there. It returns the
+// paths it placed, which are below target when directories were merged.
+func merge(path, target string) ([]string, error) {
+	existing, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return []string{target}, os.Rename(path, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() || !existing.IsDir() {
+		if err := os.RemoveAll(target); err != nil {
+			return nil, err
+		}
+
+		return []string{target}, os.Rename(path, target)
+	}
+
+	objects, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, obj := range objects {
+		placed, err := merge(filepath.Join(path, obj.Name()), filepath.Join(target, obj.Name()))
+		paths = append(paths, placed...)
+		if err != nil {
+			return paths, err
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,65 @@
+package fetcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubtreeRoot(t *testing.T) {
+	tests := []struct {
+		name    string
+		subtree Subtree
+		root    string
+		ok      bool
+	}{
+		{"strip", Subtree{StripComponents: 1}, "", true},
+		{"subpath", Subtree{Subpath: "repo-1.2/src"}, "repo-1.2/src", true},
+		{"link inside", Subtree{Subpath: "repo-1.2/inside"}, "repo-1.2/inside", true},
+		{"missing subpath", Subtree{Subpath: "repo-1.2/missing"}, "", false},
+		{"subpath to a file", Subtree{Subpath: "repo-1.2/src/a.txt"}, "", false},
+		{"parent subpath", Subtree{Subpath: "../victim"}, "", false},
+		{"link outside", Subtree{Subpath: "repo-1.2/escape"}, "", false},
+		{"through a link outside", Subtree{Subpath: "repo-1.2/escape/sub"}, "", false},
+		{"absolute link", Subtree{Subpath: "repo-1.2/absolute"}, "", false},
+		{"stripped link outside", Subtree{StripComponents: 1, Subpath: "escape"}, "", false},
+		{"too many components", Subtree{StripComponents: 3}, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := t.TempDir()
+
+			// A directory next to the project that must never be touched.
+			victim := filepath.Join(parent, "victim")
+			writeTree(t, victim, map[string]string{"secret.txt": "x", "sub/b.txt": "y"})
+
+			dir := filepath.Join(parent, "dir")
+			writeTree(t, dir, map[string]string{"repo-1.2/src/a.txt": "a"})
+			for link, target := range map[string]string{
+				"repo-1.2/inside":   "src",
+				"repo-1.2/escape":   "../../victim",
+				"repo-1.2/absolute": victim,
+			} {
+				if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			root, err := test.subtree.root("source", dir)
+			if test.ok && err != nil {
+				t.Fatalf("root failed: %s", err)
+			}
+			if !test.ok {
+				if err == nil {
+					t.Fatalf("root = %s, expected an error", root)
+				}
+				return
+			}
+
+			if test.root != "" && root != filepath.Join(dir, test.root) {
+				t.Errorf("root = %s, expected %s", root, filepath.Join(dir, test.root))
+			}
+		})
+	}
+}
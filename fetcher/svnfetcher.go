@@ -1,18 +1,38 @@
 package fetcher
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"path/filepath"
-	"os/exec"
+	"regexp"
+	"strings"
 )
 
+// SvnOptions are the optional settings of an SvnFetcher.
+type SvnOptions struct {
+	// Export places a plain tree without the .svn directory.
+	Export bool
+
+	// Username and Password authenticate to the repository. The password is
+	// passed on stdin, so it isn't seen in the process list, and is never
+	// cached by svn.
+	Username string
+	Password string
+}
+
+// svnRevision matches a version that is a revision, such as r12345.
+var svnRevision = regexp.MustCompile(`^r?([0-9]+)$`)
+
 // NewSvnFetcher gets a new new SvnFetcher
-func NewSvnFetcher(source, destination, rename, version string) *SvnFetcher {
+func NewSvnFetcher(source, destination, rename, version string, options SvnOptions) *SvnFetcher {
 	sf := new(SvnFetcher)
 
 	sf.source = source
 	sf.destination = destination
 	sf.rename = rename
 	sf.version = version
+	sf.options = options
 
 	return sf
 }
@@ -20,6 +40,20 @@ func NewSvnFetcher(source, destination, rename, version string) *SvnFetcher {
 // SvnFetcher fetches source code from git.
 type SvnFetcher struct {
 	rename, source, destination, version string
+	options                              SvnOptions
+
+	// pinned is the revision to check out, resolved is the revision that was
+	// checked out.
+	pinned, resolved string
+
+	// paths are where the repository was checked out, or where the files
+	// of an export were placed.
+	paths []string
+
+	cache *Cache
+
+	// redact masks secrets in the source recorded by an export.
+	redact func(string) string
 }
 
 // GetSource returns the location of the source code and is required by the
@@ -34,14 +68,164 @@ func (sf *SvnFetcher) GetDestination() string {
 	return sf.destination
 }
 
+// Resolved returns the revision that was checked out and is required by the
+// Pinner interface.
+func (sf *SvnFetcher) Resolved() string {
+	return sf.resolved
+}
+
+// Pin sets the revision to check out and is required by the Pinner interface.
+func (sf *SvnFetcher) Pin(identity string) {
+	sf.pinned = identity
+}
+
+// SetCache is required by the Cacher interface. Subversion checkouts are not
+// cached, but an offline cache means they can't be fetched at all.
+func (sf *SvnFetcher) SetCache(cache *Cache) {
+	sf.cache = cache
+}
+
+// Paths returns the checkout and is required by the Placer interface.
+func (sf *SvnFetcher) Paths() []string {
+	return sf.paths
+}
+
+// SetRedact sets the function that masks secrets in the source recorded by
+// an export and is required by the Redacter interface.
+func (sf *SvnFetcher) SetRedact(redact func(string) string) {
+	sf.redact = redact
+}
+
+// Export makes the fetcher place a plain tree without the .svn directory and
+// is required by the Exporter interface.
+func (sf *SvnFetcher) Export() {
+	sf.options.Export = true
+}
+
 // Fetch fetches the source code and is required by the Fetcher interface.
-func (sf *SvnFetcher) Fetch(baseDir string) error {
+func (sf *SvnFetcher) Fetch(ctx context.Context, baseDir string) (err error) {
+	if sf.cache != nil && sf.cache.Offline {
+		return OfflineError{sf.source}
+	}
+
+	dest := filepath.Join(baseDir, sf.destination, sf.rename)
+	defer cleanup(dest, &err)()
+	sf.paths = []string{dest}
+
+	if sf.options.Export {
+		sf.paths, err = exportTree(dest, ".svn", Subtree{}, sf.redact, func(dir string) (ExportInfo, error) {
+			err := sf.checkout(ctx, dir)
+			return ExportInfo{"svn", sf.source, sf.version, sf.resolved}, err
+		})
+		return err
+	}
+
+	return sf.checkout(ctx, dest)
+}
+
+// checkout checks the repository out into dest.
+func (sf *SvnFetcher) checkout(ctx context.Context, dest string) error {
+	_, err := sf.svn(ctx, "co", sf.location(), dest)
+	if err != nil {
+		return err
+	}
+
+	return sf.resolve(ctx, dest)
+}
+
+// location returns the URL to check out, with the revision as its peg
+// revision. A version like r12345 is a revision of the source, and one like
+// branches/x or tags/y is a path in the repository, relative to the source
+// without its trunk. The pinned revision takes precedence over the version's.
+func (sf *SvnFetcher) location() string {
+	url := strings.TrimSuffix(sf.source, "/")
+	revision := "HEAD"
+
+	switch {
+	case sf.version == "" || sf.version == "HEAD":
+	case svnRevision.MatchString(sf.version):
+		revision = svnRevision.FindStringSubmatch(sf.version)[1]
+	default:
+		url = strings.TrimSuffix(url, "/trunk") + "/" + strings.Trim(sf.version, "/")
+	}
+
+	if sf.pinned != "" {
+		revision = sf.pinned
+	}
+
+	return url + "@" + revision
+}
+
+// svn runs an svn subcommand with the credentials, and never lets it prompt
+// for anything.
+func (sf *SvnFetcher) svn(ctx context.Context, args ...string) (string, error) {
+	args = append(args, "--non-interactive")
+	if sf.options.Username != "" {
+		args = append(args, "--username", sf.options.Username)
+	}
+
+	if sf.options.Password != "" {
+		args = append(args, "--password-from-stdin", "--no-auth-cache")
+		return runInput(ctx, "", sf.options.Password+"\n", "svn", args...)
+	}
+
+	return run(ctx, "", "svn", args...)
+}
+
+// Update switches an existing checkout to the version. Local changes, such as
+// applied patches, are reverted first. It is required by the Updater
+// interface.
+func (sf *SvnFetcher) Update(ctx context.Context, baseDir string, previous Placement) error {
+	if sf.cache != nil && sf.cache.Offline {
+		return OfflineError{sf.source}
+	}
+
 	dest := filepath.Join(baseDir, sf.destination, sf.rename)
-	cmd := exec.Command("svn", "co", sf.source, dest)
-	err := cmd.Run()
+	sf.paths = []string{dest}
+
+	// An export has no working copy to update, so it is placed again. The
+	// same goes for a project that was exported before, but no longer is.
+	_, statErr := os.Stat(filepath.Join(dest, ExportFilename))
+	if sf.options.Export || statErr == nil {
+		for _, path := range previous.Paths {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+
+		return sf.Fetch(ctx, baseDir)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, ".svn")); err != nil {
+		return fmt.Errorf("%s is not an svn checkout and can't be updated", dest)
+	}
+
+	_, err := run(ctx, "", "svn", "revert", "-R", dest)
+	if err != nil {
+		return err
+	}
+
+	// Switch rather than update, in case the version is a different branch
+	// or tag now.
+	_, err = sf.svn(ctx, "switch", "--ignore-ancestry", sf.location(), dest)
 	if err != nil {
 		return err
 	}
 
+	return sf.resolve(ctx, dest)
+}
+
+// resolve records the revision of the checkout.
+func (sf *SvnFetcher) resolve(ctx context.Context, dest string) error {
+	out, err := run(ctx, "", "svn", "info", "--show-item", "revision", dest)
+	if err != nil {
+		return err
+	}
+	sf.resolved = strings.TrimSpace(out)
+
+	if sf.pinned != "" && sf.pinned != sf.resolved {
+		return PinError{sf.source, sf.pinned, sf.resolved}
+	}
+
 	return nil
 }
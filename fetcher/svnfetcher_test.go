@@ -0,0 +1,34 @@
+package fetcher
+
+import "testing"
+
+func TestSvnLocation(t *testing.T) {
+	tests := []struct {
+		source, version, pinned string
+		location                string
+	}{
+		{"https://svn.example.com/repo/trunk", "", "", "https://svn.example.com/repo/trunk@HEAD"},
+		{"https://svn.example.com/repo/trunk/", "HEAD", "", "https://svn.example.com/repo/trunk@HEAD"},
+		{"https://svn.example.com/repo/trunk", "r12345", "", "https://svn.example.com/repo/trunk@12345"},
+		{"https://svn.example.com/repo/trunk", "12345", "", "https://svn.example.com/repo/trunk@12345"},
+		{"https://svn.example.com/repo/trunk", "tags/1.2", "", "https://svn.example.com/repo/tags/1.2@HEAD"},
+		{"https://svn.example.com/repo/trunk/", "/tags/1.2/", "", "https://svn.example.com/repo/tags/1.2@HEAD"},
+		{"https://svn.example.com/repo/trunk", "branches/stable", "", "https://svn.example.com/repo/branches/stable@HEAD"},
+		{"https://svn.example.com/repo", "branches/stable", "", "https://svn.example.com/repo/branches/stable@HEAD"},
+
+		// The pinned revision wins over the version's.
+		{"https://svn.example.com/repo/trunk", "", "999", "https://svn.example.com/repo/trunk@999"},
+		{"https://svn.example.com/repo/trunk", "r12345", "999", "https://svn.example.com/repo/trunk@999"},
+		{"https://svn.example.com/repo/trunk", "tags/1.2", "999", "https://svn.example.com/repo/tags/1.2@999"},
+	}
+
+	for _, test := range tests {
+		sf := NewSvnFetcher(test.source, "", "", test.version, SvnOptions{})
+		sf.Pin(test.pinned)
+
+		if location := sf.location(); location != test.location {
+			t.Errorf("location of %s at %q pinned to %q = %s, expected %s",
+				test.source, test.version, test.pinned, location, test.location)
+		}
+	}
+}
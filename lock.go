@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LockedProject records the exact identity a project resolved to.
+type LockedProject struct {
+	Provider string `yaml:"provider"`
+	Source   string `yaml:"source"`
+	Resolved string `yaml:"resolved"`
+}
+
+// Lockfile records the resolved identity of every project in a manifest so
+// the same tree can be assembled again later.
+type Lockfile struct {
+	Projects map[string]*LockedProject `yaml:"projects"`
+
+	// Needed to ensure exclusive access to the Projects map with concurrent
+	// goroutines.
+	mutex sync.Mutex
+}
+
+// LockfileName returns the name of the lockfile that belongs to a manifest,
+// for example tasc-manifest.lock for tasc-manifest.yml.
+func LockfileName(manifestFilename string) string {
+	ext := filepath.Ext(manifestFilename)
+	return strings.TrimSuffix(manifestFilename, ext) + ".lock"
+}
+
+// lockSource strips any credentials from a source, and masks the values of
+// secret params anywhere else in it, so they are not written to the lockfile
+// or the state.
+func lockSource(source string, redactor *Redactor) string {
+	u, err := url.Parse(source)
+	if err == nil && u.User != nil {
+		u.User = nil
+		source = u.String()
+	}
+
+	return redactor.Redact(source)
+}
+
+// Get returns the locked state of a project, or nil if it is not locked.
+func (l *Lockfile) Get(project *Project) *LockedProject {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.Projects[project.Name]
+}
+
+// Set records the source and resolved identity of a project. The source must
+// already be stripped of secrets by lockSource.
+func (l *Lockfile) Set(project *Project, source, resolved string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.Projects == nil {
+		l.Projects = make(map[string]*LockedProject)
+	}
+
+	l.Projects[project.Name] = &LockedProject{
+		Provider: project.Provider,
+		Source:   source,
+		Resolved: resolved,
+	}
+}
+
+// Len returns the number of locked projects.
+func (l *Lockfile) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.Projects)
+}
+
+// Load a lockfile from a yaml filename.
+func (l *Lockfile) Load(filename string) error {
+	lb, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return LoadError{"Error loading lockfile"}
+	}
+
+	err = yaml.Unmarshal(lb, l)
+	if err != nil {
+		return ParseError{"Error parsing lockfile"}
+	}
+
+	return nil
+}
+
+// Save writes the lockfile to a yaml filename.
+func (l *Lockfile) Save(filename string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	lb, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, lb, 0644)
+}
+
+// LockError is for when a project can not be assembled from the lockfile.
+type LockError struct {
+	msg string
+}
+
+// Error returns the lock error message.
+func (e LockError) Error() string {
+	return e.msg
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"tasc/fetcher"
 
 	"github.com/gosuri/uilive"
 )
@@ -31,17 +37,78 @@ Written by Brendan Anderson
 	VERSION = "v0.2.2"
 )
 
+// Exit codes of the application.
+const (
+	ExitSuccess     = 0   // Everything was fetched and patched.
+	ExitError       = 1   // Some other error, such as an unwritable report.
+	ExitUsage       = 2   // Invalid command line flags or params.
+	ExitManifest    = 3   // The manifest or lockfile could not be loaded.
+	ExitFetchFailed = 4   // One or more projects failed to fetch.
+	ExitPatchFailed = 5   // One or more patches failed to apply.
+	ExitInterrupted = 130 // Interrupted with Ctrl-C or SIGTERM.
+)
+
 var (
 	manifest         Manifest
 	destinationDir   string
 	extraParams      map[string]string
 	manifestFilename string
+	lockfile         Lockfile
+	locked           bool
+	jobs             int
+	cacheDir         string
+	offline          bool
+	reportFilename   string
+	output           string
+	renderer         Renderer
+	failFast         bool
+	inPlace          bool
+	update           bool
+	staging          *Staging
+	buildDir         string
+	validateOnly     bool
+	migrate          bool
+	redactor         *Redactor
+	export           bool
+
+	// messages is where everything other than the progress is written. In
+	// json output mode that is stderr, so stdout only contains JSON.
+	messages io.Writer = os.Stdout
 
 	version bool
 )
 
-func init() {
-	var extraParamsJSON string
+// isTerminal reports whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// printManifestError prints why a manifest or lockfile could not be loaded.
+// Validation errors are printed one per line, prefixed with the filename so
+// editors can jump to them.
+func printManifestError(filename string, err error) {
+	var errs ValidationErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", filename, redactor.Redact(e.Error()))
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %s\n", filename, redactor.Redact(err.Error()))
+}
+
+// setup parses the flags and loads the manifest, and the lockfile when
+// building locked. It exits for the commands and flags that don't build
+// anything, and when something is wrong.
+func setup() {
+	var extraParamsJSON, paramsFilename string
+	paramFlag := make(ParamFlag)
 
 	flag.StringVar(&manifestFilename, "manifest", "tasc-manifest.yml",
 		"Name of the manifest file.")
@@ -49,53 +116,309 @@ func init() {
 		"Where to build the project")
 	flag.StringVar(&extraParamsJSON, "params", "{}",
 		"A JSON encoded string with extra parameters.")
+	flag.StringVar(&paramsFilename, "params-file", "",
+		"A YAML or JSON file with extra parameters.")
+	flag.Var(paramFlag, "param",
+		"An extra parameter as key=value. May be repeated.")
+	flag.BoolVar(&locked, "locked", false,
+		"Fetch exactly the versions recorded in the lockfile.")
+	flag.IntVar(&jobs, "jobs", 0,
+		"How many projects to fetch at once. Overrides the manifest.")
+
+	defaultCacheDir, err := os.UserCacheDir()
+	if err == nil {
+		defaultCacheDir = filepath.Join(defaultCacheDir, "tasc")
+	}
+	flag.StringVar(&cacheDir, "cache-dir", defaultCacheDir,
+		"Where to cache fetched content between runs. Empty disables the cache.")
+	flag.BoolVar(&offline, "offline", false,
+		"Only use content from the cache, never the network.")
+	flag.StringVar(&reportFilename, "report", "",
+		"Write a JSON report of the run to this file.")
+	flag.StringVar(&output, "output", "",
+		"How to show progress: table, plain or json. (default table on a terminal, plain otherwise)")
+	flag.BoolVar(&failFast, "fail-fast", false,
+		"Stop fetching and skip patches as soon as a blocking project fails.")
+	flag.BoolVar(&inPlace, "in-place", false,
+		"Build directly in the destination instead of a staging directory.")
+	flag.BoolVar(&update, "update", false,
+		"Update an existing destination instead of building from scratch. Implies -in-place.")
+	flag.BoolVar(&export, "export", false,
+		"Place git and svn projects without their .git or .svn directories.")
 
 	flag.BoolVar(&version, "version", false, "Print the version.")
 	flag.BoolVar(&version, "v", false, "Print the version.")
 
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, fmt.Sprintf(BANNER, VERSION))
+		fmt.Fprintln(os.Stderr, "Usage: tasc [validate|migrate] [flags]")
 		flag.PrintDefaults()
 	}
 
-	flag.Parse()
+	// "tasc validate" only checks the manifest, "tasc migrate" upgrades it to
+	// the current version.
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "validate":
+			validateOnly = true
+			args = args[1:]
+		case "migrate":
+			migrate = true
+			args = args[1:]
+		}
+	}
+	flag.CommandLine.Parse(args)
 
 	if version {
 		fmt.Printf("tasc %s\n", VERSION)
 		os.Exit(0)
 	}
 
+	if migrate {
+		migrated, err := Migrate(manifestFilename)
+		switch {
+		case err != nil:
+			printManifestError(manifestFilename, err)
+			os.Exit(ExitManifest)
+		case migrated:
+			fmt.Printf("Migrated %s to version %d.\n", manifestFilename, ManifestVersion)
+		default:
+			fmt.Printf("%s is already version %d.\n", manifestFilename, ManifestVersion)
+		}
+		os.Exit(ExitSuccess)
+	}
+
+	// Params are taken from, in increasing order of precedence, TASC_PARAM_*
+	// environment variables, -params-file, -params and -param.
+	extraParams = make(map[string]string)
+	if paramsFilename != "" {
+		extraParams, err = LoadParamsFile(paramsFilename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", paramsFilename, err.Error())
+			os.Exit(ExitUsage)
+		}
+	}
+
 	// extraParamsJson is a JSON encoded string, so we need to decode it.
-	extraParams := make(map[string]string)
-	json.Unmarshal([]byte(extraParamsJSON), &extraParams)
+	jsonParams := make(map[string]string)
+	if err = json.Unmarshal([]byte(extraParamsJSON), &jsonParams); err != nil {
+		fmt.Fprintf(os.Stderr, "-params is not a JSON object of strings: %s\n", err.Error())
+		os.Exit(ExitUsage)
+	}
+	for key, value := range jsonParams {
+		extraParams[key] = value
+	}
+
+	for key, value := range paramFlag {
+		extraParams[key] = value
+	}
+
+	// Build in a staging directory next to the destination, unless that would
+	// move the manifest itself out of the way when it is swapped into place.
+	buildDir = destinationDir
+	if !inPlace && !update && !validateOnly {
+		staging, err = NewStaging(destinationDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(ExitUsage)
+		}
+
+		if staging.Contains(manifestFilename) {
+			fmt.Fprintf(os.Stderr,
+				"%s is inside the destination, building in place.\n",
+				manifestFilename,
+			)
+			staging = nil
+		} else {
+			buildDir = staging.Dir
+		}
+	}
 
 	// Add some constants to params
 	extraParams["manifest_dir"] = filepath.Dir(manifestFilename)
-	extraParams["destination_dir"] = destinationDir
+	extraParams["destination_dir"] = buildDir
+
+	if output == "" {
+		output = "plain"
+		if isTerminal(os.Stdout) {
+			output = "table"
+		}
+	}
+
+	renderer, err = NewRenderer(output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(ExitUsage)
+	}
+
+	if output == "json" {
+		messages = os.Stderr
+	}
+
+	if offline && cacheDir == "" {
+		fmt.Fprintln(os.Stderr, "-offline needs a -cache-dir.")
+		os.Exit(ExitUsage)
+	}
 
 	// Load the manifest
-	err := manifest.Load(manifestFilename, extraParams)
+	err = manifest.Load(manifestFilename, extraParams)
+	redactor = NewRedactor(manifest.Secrets)
 	if err != nil {
-		panic(err)
+		printManifestError(manifestFilename, err)
+		os.Exit(ExitManifest)
+	}
+
+	if validateOnly {
+		fmt.Printf("%s is valid.\n", manifestFilename)
+		os.Exit(ExitSuccess)
+	}
+
+	// Load the lockfile
+	if locked {
+		lockfileName := LockfileName(manifestFilename)
+		err = lockfile.Load(lockfileName)
+		if err != nil {
+			printManifestError(lockfileName, err)
+			os.Exit(ExitManifest)
+		}
 	}
 }
 
 func main() {
-	tasc := Tasc{manifest: manifest, destination: destinationDir}
+	setup()
+	os.Exit(run())
+}
+
+// run builds the source code and, when building in a staging directory,
+// swaps it into place if the build succeeded. It returns the exit code.
+func run() int {
+	if staging != nil {
+		if err := staging.Prepare(); err != nil {
+			fmt.Fprintf(messages, "Could not create %s: %s\n", staging.Dir, err.Error())
+			return ExitError
+		}
+	}
+
+	code := build()
+	if staging == nil {
+		return code
+	}
+
+	switch code {
+	case ExitFetchFailed, ExitPatchFailed, ExitInterrupted:
+		staging.Discard()
+		fmt.Fprintf(messages, "%s was left unchanged.\n", staging.Destination)
+	default:
+		if err := staging.Commit(); err != nil {
+			fmt.Fprintf(messages,
+				"Could not move %s into place: %s\n", staging.Dir, err.Error(),
+			)
+			return ExitError
+		}
+	}
+
+	return code
+}
+
+// build assembles and patches the source code and returns the exit code.
+func build() int {
+	tasc := Tasc{
+		manifest:    manifest,
+		destination: buildDir,
+		lock:        &lockfile,
+		locked:      locked,
+		jobs:        jobs,
+		report:      NewRunReport(),
+		failFast:    failFast,
+		state:       &State{},
+		previous:    &State{},
+		update:      update,
+		redactor:    redactor,
+		export:      export,
+	}
+
+	if update {
+		stateFilename := filepath.Join(buildDir, StateFilename)
+		if err := tasc.previous.Load(stateFilename); err != nil {
+			fmt.Fprintf(messages, "%s: %s\n", stateFilename, err.Error())
+			return ExitError
+		}
+	}
+
+	if cacheDir != "" {
+		tasc.cache = fetcher.NewCache(cacheDir, offline)
+	}
+
+	// Cancel the assembly on Ctrl-C so running fetches are aborted and clean
+	// up after themselves.
+	ctx, stop := signal.NotifyContext(
+		context.Background(), os.Interrupt, syscall.SIGTERM,
+	)
+	defer stop()
 
 	c := make(chan string)
-	go tasc.Assemble(c)
+	progress := NewProgress(c, renderer, redactor)
+	go tasc.Assemble(ctx, progress)
 
-	writer := uilive.New()
-	writer.Start()
+	if output == "table" {
+		// The table is redrawn in place as it changes.
+		writer := uilive.New()
+		writer.Start()
+
+		for s := range c {
+			fmt.Fprint(writer, s)
+			writer.Flush()
+		}
 
-	for s := range c {
-		fmt.Fprintf(writer, s)
 		writer.Flush()
+		writer.Stop()
+	} else {
+		for s := range c {
+			fmt.Print(s)
+		}
+	}
+
+	// Report on what happened to each project.
+	if output == "json" {
+		rb, _ := json.Marshal(tasc.report)
+		fmt.Println(string(rb))
+	} else {
+		fmt.Print(tasc.report)
+	}
+	var err error
+	if reportFilename != "" {
+		if err = tasc.report.Save(reportFilename); err != nil {
+			fmt.Fprintf(messages, "Could not write %s: %s\n", reportFilename, err.Error())
+		}
+	}
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(messages, "Interrupted.")
+		return ExitInterrupted
+	}
+
+	// Record what was assembled so a later run can update it with -update.
+	if stateErr := tasc.WriteState(); stateErr != nil {
+		err = stateErr
+		fmt.Fprintf(messages, "Could not write %s: %s\n", StateFilename, err.Error())
 	}
 
-	writer.Flush()
-	writer.Stop()
+	// Record what was fetched so the same tree can be assembled with -locked.
+	lockfileName := LockfileName(manifestFilename)
+	written, lockErr := tasc.WriteLock(lockfileName)
+	switch {
+	case lockErr != nil:
+		err = lockErr
+		fmt.Fprintf(messages, "Could not write %s: %s\n", lockfileName, err.Error())
+	case written:
+		fmt.Fprintf(messages, "Wrote %s.\n", lockfileName)
+	}
+
+	if tasc.aborted {
+		fmt.Fprintln(messages, "A blocking project failed, patches were skipped.")
+		return ExitFetchFailed
+	}
 
 	results := tasc.Patch()
 
@@ -103,18 +426,36 @@ func main() {
 	if len(results) > 0 {
 		numSuccess := len(results.GetSuccess())
 		if numSuccess > 0 {
-			fmt.Printf("%d patches successfully applied.\n", numSuccess)
+			fmt.Fprintf(messages, "%d patches successfully applied.\n", numSuccess)
+		}
+
+		numSkipped := len(results.GetSkipped())
+		if numSkipped > 0 {
+			fmt.Fprintf(messages, "%d patches were already applied and skipped.\n", numSkipped)
 		}
 
 		numFailed := len(results.GetFailed())
 		if len(results.GetFailed()) > 0 {
-			fmt.Printf(
+			fmt.Fprintf(messages,
 				"%d patches failed to apply. Errors are listed below:\n",
 				numFailed,
 			)
 			for _, r := range results.GetFailed() {
-				fmt.Printf("%s: %s\n", r.Patcher.GetSource(), r.Error.Error())
+				fmt.Fprintln(messages, redactor.Redact(
+					fmt.Sprintf("%s: %s", r.Patcher.GetSource(), r.Error.Error()),
+				))
 			}
 		}
 	}
+
+	switch {
+	case len(tasc.report.Failed()) > 0:
+		return ExitFetchFailed
+	case len(results.GetFailed()) > 0:
+		return ExitPatchFailed
+	case err != nil:
+		return ExitError
+	}
+
+	return ExitSuccess
 }
@@ -3,10 +3,11 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strings"
 	"tasc/patcher"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 // LoadError is for when the manifest file won't load.
@@ -29,47 +30,168 @@ func (e ParseError) Error() string {
 	return e.msg
 }
 
+// DependencyError is for when the project dependencies can't be resolved.
+type DependencyError struct {
+	msg string
+}
+
+// Error returns the dependency error message.
+func (e DependencyError) Error() string {
+	return e.msg
+}
+
+// ManifestVersion is the newest manifest format tasc understands. Manifests
+// without a version are version 1.
+const ManifestVersion = 2
+
 // The Manifest is the structural representation of the manifest.
 type Manifest struct {
-	Projects []*Project
-	Patches  []*patcher.Patch
+	Version     int
+	Params      map[string]ParamSpec
+	Projects    []*Project
+	Patches     []*patcher.Patch
+	Concurrency Concurrency
+
+	// Secrets are the values of the secret params, which must never be
+	// shown. Set when the manifest is loaded, even if it isn't valid.
+	Secrets []string
 }
 
-// BlockingProjects returns slices of the blocking and non-blocking projects.
-func (m *Manifest) BlockingProjects() (SortProject, SortProject) {
-	var s SortProject
-	var a SortProject
+// resolveDependencies links each project to the projects it depends on and
+// makes sure the resulting graph has no cycles.
+//
+// Projects that don't declare depends_on keep the behaviour of the "blocking"
+// tag: blocking projects are processed one after another, and every other
+// project waits for all of them.
+func (m *Manifest) resolveDependencies() error {
+	byName := make(map[string]*Project)
+	duplicates := make(map[string]bool)
+	var blocking SortProject
 
 	for _, project := range m.Projects {
+		if _, ok := byName[project.Name]; ok {
+			duplicates[project.Name] = true
+		}
+		byName[project.Name] = project
+
 		if project.Blocking {
-			s = append(s, project)
-		} else {
-			a = append(a, project)
+			blocking = append(blocking, project)
 		}
 	}
+	sort.Sort(blocking)
 
-	return s, a
+	for _, project := range m.Projects {
+		project.dependencies = nil
+
+		switch {
+		case project.DependsOn != nil:
+			for _, name := range project.DependsOn {
+				dep, ok := byName[name]
+				switch {
+				case !ok:
+					return DependencyError{fmt.Sprintf(
+						"%s depends on unknown project %s", project.Name, name,
+					)}
+				case duplicates[name]:
+					return DependencyError{fmt.Sprintf(
+						"%s depends on %s, but more than one project is named %s",
+						project.Name, name, name,
+					)}
+				}
+				project.dependencies = append(project.dependencies, dep)
+			}
+		case project.Blocking:
+			for i, b := range blocking {
+				if b == project && i > 0 {
+					project.dependencies = append(project.dependencies, blocking[i-1])
+				}
+			}
+		default:
+			project.dependencies = append(project.dependencies, blocking...)
+		}
+	}
+
+	return m.checkCycles()
+}
+
+// checkCycles returns a DependencyError describing the first dependency cycle
+// found, if any.
+func (m *Manifest) checkCycles() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*Project]int)
+
+	var visit func(project *Project, path []string) error
+	visit = func(project *Project, path []string) error {
+		path = append(path, project.Name)
+
+		switch state[project] {
+		case visiting:
+			return DependencyError{fmt.Sprintf(
+				"dependency cycle: %s", strings.Join(path, " -> "),
+			)}
+		case visited:
+			return nil
+		}
+
+		state[project] = visiting
+		for _, dep := range project.dependencies {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		state[project] = visited
+
+		return nil
+	}
+
+	for _, project := range m.Projects {
+		if err := visit(project, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // UnmarshalYAML is an implementation of the YAML Unmarshaler interface so we
 // can have better control over how a Manifest us created from YAML.
-func (m *Manifest) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	f := make(map[string][]map[string]interface{})
+func (m *Manifest) UnmarshalYAML(value *yaml.Node) error {
+	var f struct {
+		Version     int                  `yaml:"version"`
+		Params      map[string]ParamSpec `yaml:"params"`
+		Projects    []ProjectSpec        `yaml:"projects"`
+		Patches     []patcher.PatchSpec  `yaml:"patches"`
+		Concurrency Concurrency          `yaml:"concurrency"`
+	}
 
 	// First, lets get the original unmarshalled value
-	if err := unmarshal(f); err != nil {
+	if err := value.Decode(&f); err != nil {
 		return err
 	}
 
+	m.Version = f.Version
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	m.Params = f.Params
+	m.Concurrency = f.Concurrency
+
 	// Projects
-	for _, pr := range f["projects"] {
-		project := NewProjectFromMap(pr)
+	for _, spec := range f.Projects {
+		project, err := NewProject(spec)
+		if err != nil {
+			return err
+		}
 		m.Projects = append(m.Projects, project)
 	}
 
 	// Patches
-	for _, pa := range f["patches"] {
-		patch := patcher.NewPatchFromMap(pa)
+	for _, spec := range f.Patches {
+		patch := patcher.NewPatch(spec)
 		m.Patches = append(m.Patches, patch)
 	}
 
@@ -84,18 +206,32 @@ func (m *Manifest) Load(filename string, params map[string]string) error {
 		return LoadError{"Error loading"}
 	}
 
-	// Replace any instances of {param} with the value from params in the
-	// string represenation of the manifest (ms).
-	ms := string(mb)
-	for param, value := range params {
-		ms = strings.Replace(ms, fmt.Sprintf("{%s}", param), value, -1)
+	// Parse the manifest YAML, replace any instances of {param} in its
+	// string values, and check it before building the projects so mistakes
+	// are reported with their line and column.
+	var doc yaml.Node
+	err = yaml.Unmarshal(mb, &doc)
+	if err != nil {
+		return ParseError{fmt.Sprintf("Error parsing: %s", err)}
 	}
 
-	// Parse the manifest YAML.
-	err = yaml.Unmarshal([]byte(ms), &m)
+	m.Secrets = secretParams(&doc, params)
+
+	errs := substituteParams(&doc, params)
+	errs = append(errs, validateManifest(&doc)...)
+	if len(errs) > 0 {
+		errs.Sort()
+		return errs
+	}
+
+	if doc.Kind == 0 {
+		return nil
+	}
+
+	err = doc.Decode(m)
 	if err != nil {
-		return ParseError{"Error parsing"}
+		return ParseError{fmt.Sprintf("Error parsing: %s", err)}
 	}
 
-	return nil
+	return m.resolveDependencies()
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveDependencies(t *testing.T) {
+	tests := []struct {
+		name     string
+		projects []*Project
+
+		// dependencies are the names of the dependencies of each project.
+		dependencies map[string][]string
+		err          string
+	}{
+		{
+			name: "no dependencies",
+			projects: []*Project{
+				{Name: "a"},
+				{Name: "b"},
+			},
+			dependencies: map[string][]string{"a": nil, "b": nil},
+		},
+		{
+			name: "blocking chain",
+			projects: []*Project{
+				{Name: "c", Blocking: true},
+				{Name: "a", Blocking: true},
+				{Name: "b", Blocking: true},
+			},
+			dependencies: map[string][]string{
+				"a": nil,
+				"b": {"a"},
+				"c": {"b"},
+			},
+		},
+		{
+			name: "sticky blocking projects go last",
+			projects: []*Project{
+				{Name: "a", Blocking: true, Sticky: true},
+				{Name: "b", Blocking: true},
+			},
+			dependencies: map[string][]string{
+				"a": {"b"},
+				"b": nil,
+			},
+		},
+		{
+			name: "implicit dependencies on every blocking project",
+			projects: []*Project{
+				{Name: "moodle", Blocking: true},
+				{Name: "theme", Blocking: true},
+				{Name: "plugin"},
+				{Name: "other"},
+			},
+			dependencies: map[string][]string{
+				"moodle": nil,
+				"theme":  {"moodle"},
+				"plugin": {"moodle", "theme"},
+				"other":  {"moodle", "theme"},
+			},
+		},
+		{
+			name: "depends_on replaces the implicit dependencies",
+			projects: []*Project{
+				{Name: "moodle", Blocking: true},
+				{Name: "plugin", DependsOn: []string{}},
+				{Name: "theme", DependsOn: []string{"plugin", "moodle"}},
+			},
+			dependencies: map[string][]string{
+				"moodle": nil,
+				"plugin": nil,
+				"theme":  {"plugin", "moodle"},
+			},
+		},
+		{
+			name: "unknown dependency",
+			projects: []*Project{
+				{Name: "plugin", DependsOn: []string{"moodle"}},
+			},
+			err: "plugin depends on unknown project moodle",
+		},
+		{
+			name: "ambiguous dependency",
+			projects: []*Project{
+				{Name: "moodle"},
+				{Name: "moodle"},
+				{Name: "plugin", DependsOn: []string{"moodle"}},
+			},
+			err: "plugin depends on moodle, but more than one project is named moodle",
+		},
+		{
+			name: "cycle",
+			projects: []*Project{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"c"}},
+				{Name: "c", DependsOn: []string{"a"}},
+			},
+			err: "dependency cycle: a -> b -> c -> a",
+		},
+		{
+			name: "depending on itself",
+			projects: []*Project{
+				{Name: "a", DependsOn: []string{"a"}},
+			},
+			err: "dependency cycle: a -> a",
+		},
+		{
+			name: "cycle through a blocking project",
+			projects: []*Project{
+				{Name: "moodle", Blocking: true, DependsOn: []string{"plugin"}},
+				{Name: "plugin"},
+			},
+			err: "dependency cycle: moodle -> plugin -> moodle",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := Manifest{Projects: test.projects}
+
+			err := m.resolveDependencies()
+			if test.err != "" {
+				if _, ok := err.(DependencyError); !ok || err.Error() != test.err {
+					t.Fatalf("got error %v, expected %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, project := range m.Projects {
+				var names []string
+				for _, dep := range project.dependencies {
+					names = append(names, dep.Name)
+				}
+
+				if !reflect.DeepEqual(names, test.dependencies[project.Name]) {
+					t.Errorf("%s depends on %v, expected %v",
+						project.Name, names, test.dependencies[project.Name])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MigrateError is for when a manifest can't be upgraded to the current
+// version.
+type MigrateError struct {
+	msg string
+}
+
+// Error returns the migrate error message.
+func (e MigrateError) Error() string {
+	return e.msg
+}
+
+// Migrate upgrades the manifest in filename to the current version, keeping
+// its comments. It reports whether the file was changed, which it isn't when
+// the manifest is already current.
+func Migrate(filename string) (bool, error) {
+	mb, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false, LoadError{"Error loading"}
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(mb, &doc)
+	if err != nil {
+		return false, ParseError{fmt.Sprintf("Error parsing: %s", err)}
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 ||
+		doc.Content[0].Kind != yaml.MappingNode {
+		return false, MigrateError{"the manifest is not a mapping"}
+	}
+
+	root := doc.Content[0]
+	version := 1
+	if node := mappingValue(root, "version"); node != nil {
+		version, err = strconv.Atoi(node.Value)
+		if err != nil {
+			return false, MigrateError{fmt.Sprintf(
+				"%d:%d: version must be a number", node.Line, node.Column,
+			)}
+		}
+	}
+
+	if version >= ManifestVersion {
+		return false, nil
+	}
+
+	if projects := mappingValue(root, "projects"); projects != nil {
+		for _, project := range projects.Content {
+			if err := migrateTags(project); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	setVersion(root, ManifestVersion)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return false, err
+	}
+	encoder.Close()
+
+	return true, ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// mappingValue returns the value of a key in a mapping node, or nil if the
+// key isn't there.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// setVersion sets the version of a manifest, adding it as the first key if it
+// isn't there yet.
+func setVersion(root *yaml.Node, version int) {
+	value := strconv.Itoa(version)
+
+	if node := mappingValue(root, "version"); node != nil {
+		node.Value = value
+		node.Tag = "!!int"
+		return
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	if len(root.Content) > 0 {
+		// Keep a comment at the top of the manifest at the top.
+		key.HeadComment = root.Content[0].HeadComment
+		root.Content[0].HeadComment = ""
+	}
+
+	root.Content = append([]*yaml.Node{
+		key, {Kind: yaml.ScalarNode, Tag: "!!int", Value: value},
+	}, root.Content...)
+}
+
+// migrateTags replaces the tags of a version 1 project, such as
+// "tags: [blocking]", with the "blocking: true" field of version 2.
+func migrateTags(project *yaml.Node) error {
+	if project.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var content []*yaml.Node
+	for i := 0; i+1 < len(project.Content); i += 2 {
+		key, value := project.Content[i], project.Content[i+1]
+		if key.Value != "tags" {
+			content = append(content, key, value)
+			continue
+		}
+
+		if value.Kind != yaml.SequenceNode {
+			return MigrateError{fmt.Sprintf(
+				"%d:%d: tags must be a list", value.Line, value.Column,
+			)}
+		}
+
+		seen := make(map[string]bool)
+		for j, tag := range value.Content {
+			if !contains(projectTags, tag.Value) {
+				return MigrateError{fmt.Sprintf(
+					"%d:%d: unknown tag %q", tag.Line, tag.Column, tag.Value,
+				)}
+			}
+
+			if seen[tag.Value] {
+				continue
+			}
+			seen[tag.Value] = true
+
+			field := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: tag.Value}
+			flag := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"}
+			if j == 0 {
+				// Keep any comments that were on the tags.
+				field.HeadComment = key.HeadComment
+				flag.LineComment = value.LineComment
+			}
+			content = append(content, field, flag)
+		}
+	}
+	project.Content = content
+
+	return nil
+}
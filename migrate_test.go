@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		migrated bool
+		expected string
+		err      string
+	}{
+		{
+			name: "tags",
+			manifest: `# The moodle site.
+projects:
+  # Moodle itself.
+  - source: https://github.com/moodle/moodle.git
+    provider: git
+    tags: [blocking, sticky, blocking] # Needed first.
+  - source: https://example.com/plugin.zip
+    tags: []
+`,
+			migrated: true,
+			expected: `# The moodle site.
+version: 2
+projects:
+  # Moodle itself.
+  - source: https://github.com/moodle/moodle.git
+    provider: git
+    blocking: true # Needed first.
+    sticky: true
+  - source: https://example.com/plugin.zip
+`,
+		},
+		{
+			name: "explicit version 1",
+			manifest: `version: 1
+projects:
+  - source: a
+    tags:
+      - sticky
+`,
+			migrated: true,
+			expected: `version: 2
+projects:
+  - source: a
+    sticky: true
+`,
+		},
+		{
+			name: "already current",
+			manifest: `version: 2
+projects:
+  - source: a
+    blocking: true
+`,
+			expected: `version: 2
+projects:
+  - source: a
+    blocking: true
+`,
+		},
+		{
+			name:     "unknown tag",
+			manifest: "projects:\n  - source: a\n    tags: [slow]\n",
+			err:      `3:12: unknown tag "slow"`,
+		},
+		{
+			name:     "tags not a list",
+			manifest: "projects:\n  - source: a\n    tags: blocking\n",
+			err:      "3:11: tags must be a list",
+		},
+		{
+			name:     "version not a number",
+			manifest: "version: one\n",
+			err:      "1:10: version must be a number",
+		},
+		{
+			name:     "not a mapping",
+			manifest: "- source: a\n",
+			err:      "the manifest is not a mapping",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "tasc-manifest.yml")
+			if err := ioutil.WriteFile(filename, []byte(test.manifest), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			migrated, err := Migrate(filename)
+			if test.err != "" {
+				var me MigrateError
+				if !errors.As(err, &me) || err.Error() != test.err {
+					t.Fatalf("got error %v, expected %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migrated != test.migrated {
+				t.Errorf("migrated is %t, expected %t", migrated, test.migrated)
+			}
+
+			b, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != test.expected {
+				t.Errorf("got manifest:\n%s\nexpected:\n%s", b, test.expected)
+			}
+
+			// The migrated manifest must be valid.
+			var manifest Manifest
+			if err := manifest.Load(filename, nil); err != nil {
+				t.Errorf("the migrated manifest doesn't load: %s",
+					strings.Replace(err.Error(), "\n", "; ", -1))
+			}
+		})
+	}
+}
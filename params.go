@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParamEnvPrefix is the prefix of environment variables that give a param a
+// value, for example TASC_PARAM_GITHUB_ACCESS_TOKEN for
+// {github_access_token}.
+const ParamEnvPrefix = "TASC_PARAM_"
+
+// ParamError is for when params can't be read.
+type ParamError struct {
+	msg string
+}
+
+// Error returns the param error message.
+func (e ParamError) Error() string {
+	return e.msg
+}
+
+// ParamSpec is how a param is declared in the params section of the
+// manifest.
+type ParamSpec struct {
+	// Secret params are masked in all output.
+	Secret bool `yaml:"secret"`
+}
+
+// secretParams returns the values of the params a parsed manifest declares
+// secret.
+func secretParams(doc *yaml.Node, params map[string]string) []string {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+
+	node := mappingValue(doc.Content[0], "params")
+	if node == nil {
+		return nil
+	}
+
+	// Mistakes in the params section are reported by validateManifest.
+	specs := make(map[string]ParamSpec)
+	node.Decode(&specs)
+
+	var names []string
+	for name, spec := range specs {
+		if spec.Secret {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var secrets []string
+	for _, name := range names {
+		if value, ok := lookupParam(params, name); ok {
+			secrets = append(secrets, value)
+		}
+	}
+
+	return secrets
+}
+
+// ParamFlag collects repeated -param key=value flags.
+type ParamFlag map[string]string
+
+// String returns the params in the flag, and is required by the flag.Value
+// interface.
+func (p ParamFlag) String() string {
+	var params []string
+	for key, value := range p {
+		params = append(params, key+"="+value)
+	}
+
+	return strings.Join(params, " ")
+}
+
+// Set adds a key=value param, and is required by the flag.Value interface.
+func (p ParamFlag) Set(s string) error {
+	tokens := strings.SplitN(s, "=", 2)
+	if len(tokens) != 2 || tokens[0] == "" {
+		return ParamError{fmt.Sprintf("%q is not in the form key=value", s)}
+	}
+	p[tokens[0]] = tokens[1]
+
+	return nil
+}
+
+// LoadParamsFile reads params from a YAML (or JSON) file of keys and values.
+func LoadParamsFile(filename string) (map[string]string, error) {
+	pb, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, ParamError{fmt.Sprintf("Error loading params: %s", err)}
+	}
+
+	params := make(map[string]string)
+	err = yaml.Unmarshal(pb, &params)
+	if err != nil {
+		return nil, ParamError{fmt.Sprintf("Error parsing params: %s", err)}
+	}
+	if params == nil {
+		// The file was empty.
+		params = make(map[string]string)
+	}
+
+	return params, nil
+}
+
+// lookupParam returns the value of a param, falling back to its environment
+// variable.
+func lookupParam(params map[string]string, name string) (string, bool) {
+	if value, ok := params[name]; ok {
+		return value, true
+	}
+
+	return os.LookupEnv(ParamEnvPrefix + strings.ToUpper(name))
+}
+
+// placeholder matches a {name} or {name:-default} param placeholder.
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(:-([^{}]*))?\}`)
+
+// expandParams replaces the placeholders in s with their values from params
+// or the environment, or their defaults. Values are not expanded again, so
+// the result doesn't depend on the order of the params. It returns the names
+// of the params that have neither a value nor a default.
+func expandParams(s string, params map[string]string) (string, []string) {
+	var missing []string
+
+	expanded := placeholder.ReplaceAllStringFunc(s, func(match string) string {
+		groups := placeholder.FindStringSubmatch(match)
+		name, hasDefault, def := groups[1], groups[2] != "", groups[3]
+
+		if value, ok := lookupParam(params, name); ok {
+			return value
+		}
+		if hasDefault {
+			return def
+		}
+
+		missing = append(missing, name)
+		return match
+	})
+
+	return expanded, missing
+}
+
+// substituteParams replaces the placeholders in the string values of a
+// parsed manifest. Keys and values of other types, such as numbers, are left
+// alone, so a param can never change the structure of the manifest.
+func substituteParams(node *yaml.Node, params map[string]string) ValidationErrors {
+	var errs ValidationErrors
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.ShortTag() != "!!str" {
+			break
+		}
+
+		value, missing := expandParams(node.Value, params)
+		for _, name := range missing {
+			errs = append(errs, ValidationError{
+				Line:   node.Line,
+				Column: node.Column,
+				msg: fmt.Sprintf(
+					"unknown param %q, pass it with -param %s=value or %s%s, or give a default with {%s:-default}",
+					name, name, ParamEnvPrefix, strings.ToUpper(name), name,
+				),
+			})
+		}
+		// A quoted placeholder, like depth: "{depth}", is only quoted so
+		// YAML doesn't read it as a mapping. Let a value that is a number or
+		// boolean be one, as if it had been written there.
+		if value != node.Value {
+			probe := yaml.Node{Kind: yaml.ScalarNode, Value: value}
+			switch probe.ShortTag() {
+			case "!!int", "!!float", "!!bool":
+				node.Tag = ""
+				node.Style = 0
+			}
+		}
+		node.Value = value
+	case yaml.MappingNode:
+		// Only the values, every other node is a key.
+		for i := 1; i < len(node.Content); i += 2 {
+			errs = append(errs, substituteParams(node.Content[i], params)...)
+		}
+	default:
+		for _, child := range node.Content {
+			errs = append(errs, substituteParams(child, params)...)
+		}
+	}
+
+	return errs
+}
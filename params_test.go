@@ -0,0 +1,159 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpandParams(t *testing.T) {
+	t.Setenv(ParamEnvPrefix+"FROM_ENV", "env")
+	t.Setenv(ParamEnvPrefix+"BRANCH", "from-env")
+
+	params := map[string]string{
+		"branch": "MOODLE_39_STABLE",
+		"empty":  "",
+		"nested": "{branch}",
+	}
+
+	tests := []struct {
+		s        string
+		expanded string
+		missing  []string
+	}{
+		{"plain", "plain", nil},
+		{"{branch}", "MOODLE_39_STABLE", nil},
+		{"git@host:{branch}.git", "git@host:MOODLE_39_STABLE.git", nil},
+		{"{branch}/{branch}", "MOODLE_39_STABLE/MOODLE_39_STABLE", nil},
+		{"{branch:-master}", "MOODLE_39_STABLE", nil},
+		{"{unknown:-master}", "master", nil},
+		{"{unknown:-}", "", nil},
+		{"{unknown:-a/b:c}", "a/b:c", nil},
+		{"{empty:-default}", "", nil},
+		{"{from_env}", "env", nil},
+		{"{nested}", "{branch}", nil},
+		{"{unknown}", "{unknown}", []string{"unknown"}},
+		{"{a}-{b:-x}-{c}", "{a}-x-{c}", []string{"a", "c"}},
+		{"{not a param}", "{not a param}", nil},
+		{"{1st}", "{1st}", nil},
+		{"{}", "{}", nil},
+	}
+
+	for _, test := range tests {
+		expanded, missing := expandParams(test.s, params)
+		if expanded != test.expanded {
+			t.Errorf("expandParams(%q) = %q, expected %q", test.s, expanded, test.expanded)
+		}
+		if !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("expandParams(%q) is missing %v, expected %v", test.s, missing, test.missing)
+		}
+	}
+}
+
+func TestSubstituteParams(t *testing.T) {
+	params := map[string]string{
+		"branch": "MOODLE_39_STABLE",
+		"depth":  "1",
+		"key":    "source",
+	}
+
+	manifest := `
+projects:
+  - source: https://github.com/moodle/moodle.git
+    version: "{branch}"
+    rename: "{name:-moodle}"
+    depth: 5
+    "{key}": not a key
+  - source: "{missing}"
+    tags: ["{branch}"]
+`
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(manifest), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := substituteParams(&doc, params)
+	if len(errs) != 1 || errs[0].Line != 8 || errs[0].Column != 13 {
+		t.Errorf("expected one error for {missing} at 8:13, got %v", errs)
+	}
+
+	var decoded struct {
+		Projects []map[string]interface{} `yaml:"projects"`
+	}
+	if err := doc.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []map[string]interface{}{
+		{
+			"source":  "https://github.com/moodle/moodle.git",
+			"version": "MOODLE_39_STABLE",
+			"rename":  "moodle",
+			"depth":   5,
+			"{key}":   "not a key",
+		},
+		{
+			"source": "{missing}",
+			"tags":   []interface{}{"MOODLE_39_STABLE"},
+		},
+	}
+	if !reflect.DeepEqual(decoded.Projects, expected) {
+		t.Errorf("substituted projects are %v, expected %v", decoded.Projects, expected)
+	}
+}
+
+func TestSubstituteParamsTypes(t *testing.T) {
+	params := map[string]string{
+		"depth":   "1",
+		"branch":  "MOODLE_39_STABLE",
+		"version": "1.10",
+		"lfs":     "true",
+	}
+
+	manifest := `
+version: 2
+projects:
+  - source: https://github.com/moodle/moodle.git
+    provider: git
+    version: "{branch}"
+    depth: "{depth}"
+    lfs: "{lfs}"
+  - source: https://example.com/{version}.tar.gz
+    rename: "{version}"
+    strip_components: "{strip:-1}"
+`
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(manifest), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := substituteParams(&doc, params); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if errs := validateManifest(&doc); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	var f struct {
+		Projects []ProjectSpec `yaml:"projects"`
+	}
+	if err := doc.Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Projects) != 2 {
+		t.Fatalf("got %d projects, expected 2", len(f.Projects))
+	}
+	git, archive := f.Projects[0], f.Projects[1]
+	if git.Version != "MOODLE_39_STABLE" || git.Depth != 1 || !git.LFS {
+		t.Errorf("got version %q, depth %d and lfs %t, expected MOODLE_39_STABLE, 1 and true",
+			git.Version, git.Depth, git.LFS)
+	}
+	if archive.Rename != "1.10" || archive.StripComponents != 1 {
+		t.Errorf("got rename %q and strip_components %d, expected 1.10 and 1",
+			archive.Rename, archive.StripComponents)
+	}
+}
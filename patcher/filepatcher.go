@@ -32,6 +32,24 @@ func (p *FilePatcher) Patch() *PatchResult {
 	return &PatchResult{Error: err, Patcher: p}
 }
 
+// Applied reports whether the patch has already been applied, because it can
+// be reversed. Needed to satisfy the Checker interface.
+func (p *FilePatcher) Applied() bool {
+	// --force keeps patch from asking questions, and from assuming that a
+	// patch that doesn't reverse cleanly was meant to be applied forward.
+	err := exec.Command(
+		"patch",
+		"--reverse",
+		"--dry-run",
+		"--force",
+		"--silent",
+		fmt.Sprintf("--input=%s", p.Source),
+		p.Destination,
+	).Run()
+
+	return err == nil
+}
+
 // GetSource sets the source patch file. Needed to satisfy Patcher interface.
 func (p *FilePatcher) GetSource() string {
 	return p.Source
@@ -8,22 +8,26 @@ type Patch struct {
 	Patcher Patcher
 }
 
-// NewPatchFromMap creates a Patch from a map.
-func NewPatchFromMap(mp map[string]interface{}) *Patch {
-	patch := new(Patch)
+// PatchSpec is a patch as it is written in the manifest.
+type PatchSpec struct {
+	Type        string `yaml:"type"`
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+}
 
-	source, _ := mp["source"].(string)
-	destination, _ := mp["destination"].(string)
+// NewPatch creates a Patch from its manifest spec.
+func NewPatch(spec PatchSpec) *Patch {
+	patch := new(Patch)
 
 	// Name
-	tokens := strings.Split(source, "/")
+	tokens := strings.Split(spec.Source, "/")
 	patch.Name = tokens[len(tokens)-1]
 
-	switch mp["type"] {
+	switch spec.Type {
 	case "file":
 		fallthrough
 	default:
-		patch.Patcher = NewFilePatcher(source, destination)
+		patch.Patcher = NewFilePatcher(spec.Source, spec.Destination)
 	}
 
 	return patch
@@ -33,6 +37,9 @@ func NewPatchFromMap(mp map[string]interface{}) *Patch {
 type PatchResult struct {
 	Error   error
 	Patcher Patcher
+
+	// Skipped means the patch was already applied, so it was left alone.
+	Skipped bool
 }
 
 // PatchResults is the result of a set of patches
@@ -43,7 +50,7 @@ func (pr PatchResults) GetSuccess() PatchResults {
 	var successes PatchResults
 
 	for _, r := range pr {
-		if r.Error == nil {
+		if r.Error == nil && !r.Skipped {
 			successes = append(successes, r)
 		}
 	}
@@ -51,6 +58,19 @@ func (pr PatchResults) GetSuccess() PatchResults {
 	return successes
 }
 
+// GetSkipped gets the patches that were already applied.
+func (pr PatchResults) GetSkipped() PatchResults {
+	var skipped PatchResults
+
+	for _, r := range pr {
+		if r.Skipped {
+			skipped = append(skipped, r)
+		}
+	}
+
+	return skipped
+}
+
 // GetFailed gets the failed patches.
 func (pr PatchResults) GetFailed() PatchResults {
 	var failed PatchResults
@@ -8,3 +8,10 @@ type Patcher interface {
 	SetSource(source string)
 	SetDestination(destination string)
 }
+
+// Checker is implemented by patchers that can tell whether their patch has
+// already been applied, for example by an earlier run on a destination that
+// is being updated.
+type Checker interface {
+	Applied() bool
+}
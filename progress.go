@@ -2,12 +2,9 @@ package main
 
 // Status is a structure for communicating the status of a project.
 import (
-	"fmt"
 	"sort"
-	"strconv"
-	"strings"
 	"sync"
-	"unicode/utf8"
+	"time"
 )
 
 // ProjectState is the state of the project
@@ -19,6 +16,7 @@ const (
 	StateProcessing                     // Fetching project.
 	StateSuccess                        // Project has successfully fetched.
 	StateFailed                         // Project has failed to fetch.
+	StateSkipped                        // A dependency of the project failed.
 )
 
 // String representation of a ProjectState.
@@ -34,6 +32,8 @@ func (ps ProjectState) String() string {
 		state = "success"
 	case StateFailed:
 		state = "failed"
+	case StateSkipped:
+		state = "skipped"
 	}
 
 	return state
@@ -43,6 +43,26 @@ func (ps ProjectState) String() string {
 type Status struct {
 	Project *Project
 	State   ProjectState
+
+	// Error is why the project failed or was skipped.
+	Error error
+
+	// Start is when the project started processing, End is when it was done.
+	Start time.Time
+	End   time.Time
+}
+
+// Duration is how long the project took to process, or has been processing
+// so far.
+func (s Status) Duration() time.Duration {
+	if s.Start.IsZero() {
+		return 0
+	}
+	if s.End.IsZero() {
+		return time.Since(s.Start)
+	}
+
+	return s.End.Sub(s.Start)
 }
 
 // SortStatus represents the state of a project.
@@ -66,15 +86,26 @@ type Progress struct {
 	C               chan string
 	projectStatuses SortStatus
 
+	// renderer turns the progress into the text sent on C. events are the
+	// status changes that haven't been reported yet.
+	renderer Renderer
+	events   []Status
+
+	// redactor masks secrets, such as tokens in project errors.
+	redactor *Redactor
+
 	// Needed to ensure exclusive access to the projectStates map with
 	// concurrent goroutines.
 	mutex *sync.Mutex
 }
 
-// NewProgress creates a new progress.
-func NewProgress(c chan string) *Progress {
+// NewProgress creates a new progress that reports through the renderer,
+// with secrets masked by the redactor.
+func NewProgress(c chan string, renderer Renderer, redactor *Redactor) *Progress {
 	p := new(Progress)
 	p.C = c
+	p.renderer = renderer
+	p.redactor = redactor
 	p.mutex = &sync.Mutex{}
 
 	return p
@@ -85,11 +116,25 @@ func NewProgress(c chan string) *Progress {
 func (p *Progress) AddStatus(status Status) {
 	p.mutex.Lock()
 
+	switch status.State {
+	case StateQueued:
+	case StateProcessing:
+		status.Start = time.Now()
+	default:
+		status.End = time.Now()
+	}
+
 	var found bool
 	for _, projectStatus := range p.projectStatuses {
 		if projectStatus.Project.Name == status.Project.Name {
 			// We found an existing project status to update.
 			projectStatus.State = status.State
+			projectStatus.Error = status.Error
+			if !status.Start.IsZero() {
+				projectStatus.Start = status.Start
+			}
+			projectStatus.End = status.End
+			status = *projectStatus
 			found = true
 		}
 	}
@@ -99,6 +144,10 @@ func (p *Progress) AddStatus(status Status) {
 		p.projectStatuses = append(p.projectStatuses, &status)
 	}
 
+	if status.State != StateQueued {
+		p.events = append(p.events, status)
+	}
+
 	p.mutex.Unlock()
 }
 
@@ -109,64 +158,31 @@ func (p *Progress) Add(project *Project, state ProjectState) *Progress {
 	return p
 }
 
+// AddError is like Add, but also records why the project failed or was
+// skipped.
+func (p *Progress) AddError(project *Project, state ProjectState, err error) *Progress {
+	status := Status{Project: project, State: state, Error: err}
+	p.AddStatus(status)
+	return p
+}
+
 // QueueProjects queues a slice of projects.
 func (p *Progress) QueueProjects(projects []*Project) {
 	for _, project := range projects {
-		status := Status{project, StateQueued}
+		status := Status{Project: project, State: StateQueued}
 		p.AddStatus(status)
 	}
 }
 
-// A helper function to get the length of the longest project name
-func (p *Progress) longestProjectNameLength() int {
-	length := 0
-	for _, status := range p.projectStatuses {
-		l := utf8.RuneCountInString(status.Project.Name)
-		if l > length {
-			length = l
-		}
-	}
-
-	return length
-}
-
 // Report the status of projects
 func (p *Progress) Report() {
-	// Find the longest project name
-	length := p.longestProjectNameLength()
-
-	// Calculate the row format
-	rowElements := []string{"| %-", strconv.Itoa(length), "s | %-9s | %10s |\n"}
-	rowFormat := strings.Join(rowElements, "")
-
-	// Seperator format
-	sepElements := []string{"| %-", strconv.Itoa(length), "s | %-9s   %10s |\n"}
-	sepFormat := strings.Join(sepElements, "")
-	sepString, capString := "", ""
-	for i := 0; i < length; i++ {
-		sepString += "-"
-		capString += "_"
-	}
-	cap := fmt.Sprintf("__%s___________________________\n", capString)
-
-	report := fmt.Sprintf(rowFormat, "Projects", "Blocking", "Status")
-	report += fmt.Sprintf(sepFormat, sepString, "---------", "----------")
 	p.mutex.Lock()
 	sort.Sort(p.projectStatuses)
-	for _, status := range p.projectStatuses {
-		blocking := "No"
-
-		switch {
-		case status.Project.Blocking && status.State == StateProcessing:
-			blocking = "BLOCKING"
-		case status.Project.Blocking && status.State != StateProcessing:
-			blocking = "unblocked"
-		}
-
-		report += fmt.Sprintf(rowFormat, status.Project.Name, blocking, status.State)
-	}
+	report := p.redactor.Redact(p.renderer.Render(p.projectStatuses, p.events))
+	p.events = nil
 	p.mutex.Unlock()
 
-	report = fmt.Sprintf("%s%s%s", cap, report, cap)
-	p.C <- report
+	if report != "" {
+		p.C <- report
+	}
 }
@@ -1,17 +1,49 @@
 package main
 
 import (
-	"reflect"
+	"fmt"
+	"path/filepath"
 	"strings"
 	"tasc/fetcher"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
+// ProjectError is for when a project in the manifest is invalid.
+type ProjectError struct {
+	msg string
+}
+
+// Error returns the project error message.
+func (e ProjectError) Error() string {
+	return e.msg
+}
+
 // Project is the representation of an individual project.
 type Project struct {
 	Name     string
+	Provider string
 	Fetcher  fetcher.Fetcher
 	Blocking bool
 	Sticky   bool
+
+	// Destination is where the project is placed, relative to the
+	// destination of the assembly. It includes the rename of git, svn and hg
+	// projects.
+	Destination string
+
+	// Timeout is how long the project may take to fetch. Zero means there is
+	// no limit.
+	Timeout time.Duration
+
+	// DependsOn lists the names of the projects that must be fetched before
+	// this one. It is nil when the manifest does not declare depends_on.
+	DependsOn []string
+
+	// The projects named in DependsOn, or implied by the blocking tag. Set
+	// when the manifest is loaded.
+	dependencies []*Project
 }
 
 // SortProject is a sortable list of Project.
@@ -31,15 +63,87 @@ func (c SortProject) Less(i, j int) bool {
 	return c[j].Sticky
 }
 
+// ProjectSpec is a project as it is written in the manifest.
+type ProjectSpec struct {
+	Provider    string `yaml:"provider"`
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+	Rename      string `yaml:"rename"`
+	Version     string `yaml:"version"`
+	Checksum    string `yaml:"checksum"`
+	Timeout     string `yaml:"timeout"`
+	DependsOn   Names  `yaml:"depends_on"`
+
+	// Format is the format of a zip project's archive, when it can't be
+	// detected from its contents.
+	Format string `yaml:"format"`
+
+	// StripComponents and Subpath select the part of a zip, git or local
+	// project that is placed.
+	StripComponents int    `yaml:"strip_components"`
+	Subpath         string `yaml:"subpath"`
+
+	// Auth is how git projects authenticate to their remote, Depth and
+	// SingleBranch limit how much of it is cloned. Submodules is true or
+	// recursive, and LFS downloads Git LFS files.
+	Auth         fetcher.GitAuth `yaml:"auth"`
+	Depth        int             `yaml:"depth"`
+	SingleBranch bool            `yaml:"single_branch"`
+	Submodules   string          `yaml:"submodules"`
+	LFS          bool            `yaml:"lfs"`
+
+	// Username and Password authenticate svn projects to their repository.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// Export places git and svn projects without their .git or .svn
+	// directory.
+	Export bool `yaml:"export"`
+
+	// Blocking and Sticky replace the tags of version 1 manifests.
+	Blocking bool `yaml:"blocking"`
+	Sticky   bool `yaml:"sticky"`
+
+	// Tags is only used by version 1 manifests.
+	Tags []string `yaml:"tags"`
+}
+
+// subtree returns the part of the project that is placed.
+func (spec ProjectSpec) subtree() fetcher.Subtree {
+	return fetcher.Subtree{
+		StripComponents: spec.StripComponents,
+		Subpath:         spec.Subpath,
+	}
+}
+
+// Names is a list of project names that can also be written as a single
+// name.
+type Names []string
+
+// UnmarshalYAML is an implementation of the YAML Unmarshaler interface so a
+// single name doesn't have to be written as a list.
+func (n *Names) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*n = Names{value.Value}
+		return nil
+	}
+
+	names := []string{}
+	if err := value.Decode(&names); err != nil {
+		return err
+	}
+	*n = names
+
+	return nil
+}
+
 // InferProjectName tries to figure out the project's name.
-func InferProjectName(mp map[string]interface{}) string {
-	rename, _ := mp["rename"].(string)
-	if rename != "" {
-		return rename
+func InferProjectName(spec ProjectSpec) string {
+	if spec.Rename != "" {
+		return spec.Rename
 	}
 
-	source, _ := mp["source"].(string)
-	tokens := strings.Split(source, "/")
+	tokens := strings.Split(spec.Source, "/")
 	if tokens[len(tokens)-1] != "" {
 		return tokens[len(tokens)-1]
 	}
@@ -47,59 +151,114 @@ func InferProjectName(mp map[string]interface{}) string {
 	return "No Name"
 }
 
-// NewProjectFromMap creates a new Project from a map.
-func NewProjectFromMap(mp map[string]interface{}) *Project {
+// NewProject creates a new Project from its manifest spec.
+func NewProject(spec ProjectSpec) (*Project, error) {
 	project := new(Project)
 
-	source, _ := mp["source"].(string)
-	destination, _ := mp["destination"].(string)
-
 	// Name
-	project.Name = InferProjectName(mp)
+	project.Name = InferProjectName(spec)
 
 	// Fetcher
-	switch mp["provider"] {
+	project.Provider = spec.Provider
+	if project.Provider == "" {
+		project.Provider = "zip"
+	}
+	switch project.Provider {
 	case "git":
-		rename, _ := mp["rename"].(string)
-		version, _ := mp["version"].(string)
+		options := fetcher.GitOptions{
+			Auth:         spec.Auth,
+			Depth:        spec.Depth,
+			SingleBranch: spec.SingleBranch,
+			LFS:          spec.LFS,
+			Export:       spec.Export,
+			Subtree:      spec.subtree(),
+		}
+
+		switch spec.Submodules {
+		case "", "false":
+			options.Submodules = fetcher.NoSubmodules
+		case "true":
+			options.Submodules = fetcher.TopSubmodules
+		case "recursive":
+			options.Submodules = fetcher.RecursiveSubmodules
+		default:
+			return nil, ProjectError{fmt.Sprintf(
+				"%s has invalid submodules %q, use true or recursive",
+				project.Name, spec.Submodules,
+			)}
+		}
 
 		project.Fetcher = fetcher.NewGitFetcher(
-			source, destination, rename, version,
+			spec.Source, spec.Destination, spec.Rename, spec.Version, options,
 		)
 	case "svn":
-		rename, _ := mp["rename"].(string)
-		version, _ := mp["version"].(string)
-
 		project.Fetcher = fetcher.NewSvnFetcher(
-			source, destination, rename, version,
+			spec.Source, spec.Destination, spec.Rename, spec.Version,
+			fetcher.SvnOptions{
+				Export:   spec.Export,
+				Username: spec.Username,
+				Password: spec.Password,
+			},
+		)
+	case "hg":
+		project.Fetcher = fetcher.NewHgFetcher(
+			spec.Source, spec.Destination, spec.Rename, spec.Version,
 		)
 	case "local":
-		project.Fetcher = fetcher.NewLocalFetcher(source, destination)
+		project.Fetcher = fetcher.NewLocalFetcher(
+			spec.Source, spec.Destination,
+			fetcher.LocalOptions{Subtree: spec.subtree()},
+		)
 	case "zip":
 		fallthrough
 	default:
+		checksum := spec.Checksum
+		if checksum != "" {
+			var err error
+			checksum, err = fetcher.ParseChecksum(checksum)
+			if err != nil {
+				return nil, ProjectError{fmt.Sprintf("%s: %s", project.Name, err)}
+			}
+		}
+
 		project.Fetcher = fetcher.NewArchiveFetcher(
-			source, destination,
+			spec.Source, spec.Destination, checksum,
+			fetcher.ArchiveOptions{Format: spec.Format, Subtree: spec.subtree()},
 		)
 	}
 
+	// Destination
+	project.Destination = filepath.Clean(spec.Destination)
+	switch project.Provider {
+	case "git", "svn", "hg":
+		project.Destination = filepath.Join(spec.Destination, spec.Rename)
+	}
+
+	// Timeout
+	if spec.Timeout != "" {
+		d, err := time.ParseDuration(spec.Timeout)
+		if err != nil {
+			return nil, ProjectError{fmt.Sprintf(
+				"%s has an invalid timeout %q", project.Name, spec.Timeout,
+			)}
+		}
+		project.Timeout = d
+	}
+
+	// Dependencies
+	project.DependsOn = spec.DependsOn
+
 	// Tags
-	tags, ok := mp["tags"]
-	if ok {
-		switch reflect.TypeOf(tags).Kind() {
-		case reflect.Slice:
-			t := reflect.ValueOf(tags)
-
-			for i := 0; i < t.Len(); i++ {
-				switch t.Index(i).Interface() {
-				case "blocking":
-					project.Blocking = true
-				case "sticky":
-					project.Sticky = true
-				}
-			}
+	project.Blocking = spec.Blocking
+	project.Sticky = spec.Sticky
+	for _, tag := range spec.Tags {
+		switch tag {
+		case "blocking":
+			project.Blocking = true
+		case "sticky":
+			project.Sticky = true
 		}
 	}
 
-	return project
+	return project, nil
 }
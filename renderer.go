@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Renderer turns the progress of the assembly into text.
+type Renderer interface {
+	// Render is given the status of every project, and the status changes
+	// since it was last called in the order they happened.
+	Render(statuses SortStatus, events []Status) string
+}
+
+// NewRenderer returns the renderer for an output mode: table, plain or json.
+func NewRenderer(output string) (Renderer, error) {
+	switch output {
+	case "table":
+		return TableRenderer{}, nil
+	case "plain":
+		return PlainRenderer{}, nil
+	case "json":
+		return JSONRenderer{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown output %q, use table, plain or json", output)
+}
+
+// TableRenderer renders a table of every project, meant to be redrawn in
+// place on a terminal.
+type TableRenderer struct{}
+
+// A helper function to get the length of the longest project name
+func longestProjectNameLength(statuses SortStatus) int {
+	length := 0
+	for _, status := range statuses {
+		l := utf8.RuneCountInString(status.Project.Name)
+		if l > length {
+			length = l
+		}
+	}
+
+	return length
+}
+
+// Render renders the table. Needed to satisfy the Renderer interface.
+func (r TableRenderer) Render(statuses SortStatus, events []Status) string {
+	// Find the longest project name
+	length := longestProjectNameLength(statuses)
+
+	// Calculate the row format
+	rowElements := []string{"| %-", strconv.Itoa(length), "s | %-9s | %10s |\n"}
+	rowFormat := strings.Join(rowElements, "")
+
+	// Seperator format
+	sepElements := []string{"| %-", strconv.Itoa(length), "s | %-9s   %10s |\n"}
+	sepFormat := strings.Join(sepElements, "")
+	sepString, capString := "", ""
+	for i := 0; i < length; i++ {
+		sepString += "-"
+		capString += "_"
+	}
+	cap := fmt.Sprintf("__%s___________________________\n", capString)
+
+	report := fmt.Sprintf(rowFormat, "Projects", "Blocking", "Status")
+	report += fmt.Sprintf(sepFormat, sepString, "---------", "----------")
+	for _, status := range statuses {
+		blocking := "No"
+
+		switch {
+		case status.Project.Blocking && status.State == StateProcessing:
+			blocking = "BLOCKING"
+		case status.Project.Blocking && status.State != StateProcessing:
+			blocking = "unblocked"
+		}
+
+		report += fmt.Sprintf(rowFormat, status.Project.Name, blocking, status.State)
+	}
+
+	return fmt.Sprintf("%s%s%s", cap, report, cap)
+}
+
+// PlainRenderer renders a line per status change, for example
+// "[moodle] success 34.2s". It is meant for logs and other non-interactive
+// output.
+type PlainRenderer struct{}
+
+// Render renders the lines. Needed to satisfy the Renderer interface.
+func (r PlainRenderer) Render(statuses SortStatus, events []Status) string {
+	var lines string
+
+	for _, event := range events {
+		line := fmt.Sprintf("[%s] %s", event.Project.Name, event.State)
+		if !event.Start.IsZero() && !event.End.IsZero() {
+			line += fmt.Sprintf(" %.1fs", event.Duration().Seconds())
+		}
+		if event.Error != nil {
+			line += fmt.Sprintf(": %s", event.Error.Error())
+		}
+
+		lines += line + "\n"
+	}
+
+	return lines
+}
+
+// JSONRenderer renders a JSON object per status change, one per line.
+type JSONRenderer struct{}
+
+// jsonEvent is the JSON representation of a status change.
+type jsonEvent struct {
+	Time    time.Time `json:"time"`
+	Project string    `json:"project"`
+	State   string    `json:"state"`
+
+	// Duration is how long the project took to process, in seconds.
+	Duration float64 `json:"duration,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Render renders the JSON lines. Needed to satisfy the Renderer interface.
+func (r JSONRenderer) Render(statuses SortStatus, events []Status) string {
+	var lines string
+
+	for _, event := range events {
+		je := jsonEvent{
+			Time:    time.Now(),
+			Project: event.Project.Name,
+			State:   event.State.String(),
+		}
+		switch {
+		case !event.End.IsZero():
+			je.Time = event.End
+			je.Duration = event.Duration().Seconds()
+		case !event.Start.IsZero():
+			je.Time = event.Start
+		}
+		if event.Error != nil {
+			je.Error = event.Error.Error()
+		}
+
+		b, err := json.Marshal(je)
+		if err != nil {
+			continue
+		}
+		lines += string(b) + "\n"
+	}
+
+	return lines
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ProjectReport is what happened to a single project during a run.
+type ProjectReport struct {
+	Name     string    `json:"name"`
+	Provider string    `json:"provider"`
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+
+	// Duration is how long the project took to fetch, in seconds.
+	Duration float64 `json:"duration"`
+
+	// Bytes is how many bytes were downloaded for the project. It is nil for
+	// projects whose fetcher can't tell, like git, svn and hg.
+	Bytes *int64 `json:"bytes,omitempty"`
+
+	// Resolved is the commit, revision or checksum the project resolved to.
+	Resolved string `json:"resolved,omitempty"`
+}
+
+// RunReport collects a ProjectReport for every project in a run.
+type RunReport struct {
+	Start    time.Time        `json:"start"`
+	End      time.Time        `json:"end"`
+	Projects []*ProjectReport `json:"projects"`
+
+	// Needed to ensure exclusive access to Projects with concurrent
+	// goroutines.
+	mutex sync.Mutex
+}
+
+// NewRunReport creates a new RunReport that starts now.
+func NewRunReport() *RunReport {
+	r := new(RunReport)
+	r.Start = time.Now()
+
+	return r
+}
+
+// Add adds the report of a project.
+func (r *RunReport) Add(pr *ProjectReport) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Projects = append(r.Projects, pr)
+}
+
+// Finish marks the end of the run and sorts the project reports by name.
+func (r *RunReport) Finish() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.End = time.Now()
+	sort.Slice(r.Projects, func(i, j int) bool {
+		return r.Projects[i].Name < r.Projects[j].Name
+	})
+}
+
+// Failed returns the reports of the projects that failed or were skipped.
+func (r *RunReport) Failed() []*ProjectReport {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var failed []*ProjectReport
+	for _, pr := range r.Projects {
+		if pr.Error != "" {
+			failed = append(failed, pr)
+		}
+	}
+
+	return failed
+}
+
+// String renders the report as a table followed by the errors of the projects
+// that failed.
+func (r *RunReport) String() string {
+	var b bytes.Buffer
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Project\tStatus\tDuration\tBytes\tResolved")
+	r.mutex.Lock()
+	for _, pr := range r.Projects {
+		size := "-"
+		if pr.Bytes != nil {
+			size = fmt.Sprintf("%d", *pr.Bytes)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1fs\t%s\t%s\n",
+			pr.Name, pr.State, pr.Duration, size, pr.Resolved,
+		)
+	}
+	r.mutex.Unlock()
+	w.Flush()
+
+	failed := r.Failed()
+	if len(failed) > 0 {
+		fmt.Fprintf(&b,
+			"%d projects failed to fetch. Errors are listed below:\n",
+			len(failed),
+		)
+		for _, pr := range failed {
+			fmt.Fprintf(&b, "%s: %s\n", pr.Name, pr.Error)
+		}
+	}
+
+	return b.String()
+}
+
+// Save writes the report to a JSON file.
+func (r *RunReport) Save(filename string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rb, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, rb, 0644)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Concurrency limits how many projects are fetched at the same time. A limit
+// of zero or less means there is no limit.
+type Concurrency struct {
+	// Jobs is the maximum number of projects fetched at once.
+	Jobs int `yaml:"jobs"`
+
+	// Providers is the maximum number of projects fetched at once with a
+	// given provider, for example {"git": 4, "zip": 8}.
+	Providers map[string]int `yaml:"providers"`
+}
+
+// UnmarshalYAML is an implementation of the YAML Unmarshaler interface so the
+// concurrency can be given as a plain number of jobs as well as a map.
+func (c *Concurrency) UnmarshalYAML(value *yaml.Node) error {
+	var jobs int
+	if err := value.Decode(&jobs); err == nil {
+		c.Jobs = jobs
+		return nil
+	}
+
+	type concurrency Concurrency
+	return value.Decode((*concurrency)(c))
+}
+
+// Scheduler hands out slots to fetch projects within the limits of a
+// Concurrency.
+type Scheduler struct {
+	jobs      chan struct{}
+	providers map[string]chan struct{}
+}
+
+// NewScheduler creates a new Scheduler.
+func NewScheduler(c Concurrency) *Scheduler {
+	s := new(Scheduler)
+
+	if c.Jobs > 0 {
+		s.jobs = make(chan struct{}, c.Jobs)
+	}
+
+	s.providers = make(map[string]chan struct{})
+	for provider, limit := range c.Providers {
+		if limit > 0 {
+			s.providers[provider] = make(chan struct{}, limit)
+		}
+	}
+
+	return s
+}
+
+// Acquire blocks until the project can be fetched within the limits, or
+// until ctx is done, in which case the context's error is returned and no
+// slot is held.
+func (s *Scheduler) Acquire(ctx context.Context, proj *Project) error {
+	// Wait for the provider first so a project waiting on its provider does
+	// not hold on to one of the overall jobs.
+	p, ok := s.providers[proj.Provider]
+	if ok {
+		select {
+		case p <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if s.jobs != nil {
+		select {
+		case s.jobs <- struct{}{}:
+		case <-ctx.Done():
+			if ok {
+				<-p
+			}
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Release frees the slot acquired for the project.
+func (s *Scheduler) Release(proj *Project) {
+	if s.jobs != nil {
+		<-s.jobs
+	}
+
+	if p, ok := s.providers[proj.Provider]; ok {
+		<-p
+	}
+}
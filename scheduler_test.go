@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestConcurrencyUnmarshal(t *testing.T) {
+	tests := []struct {
+		yaml     string
+		expected Concurrency
+	}{
+		{"4", Concurrency{Jobs: 4}},
+		{"jobs: 8", Concurrency{Jobs: 8}},
+		{"{jobs: 8, providers: {git: 2}}", Concurrency{Jobs: 8, Providers: map[string]int{"git": 2}}},
+	}
+
+	for _, test := range tests {
+		var c Concurrency
+		if err := yaml.Unmarshal([]byte(test.yaml), &c); err != nil {
+			t.Errorf("unmarshalling %q failed: %s", test.yaml, err)
+			continue
+		}
+		if c.Jobs != test.expected.Jobs || len(c.Providers) != len(test.expected.Providers) ||
+			c.Providers["git"] != test.expected.Providers["git"] {
+			t.Errorf("unmarshalling %q = %+v, expected %+v", test.yaml, c, test.expected)
+		}
+	}
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	s := NewScheduler(Concurrency{Jobs: 3, Providers: map[string]int{"git": 1}})
+
+	var (
+		mutex              sync.Mutex
+		running, git       int
+		maxRunning, maxGit int
+		wg                 sync.WaitGroup
+	)
+
+	for i := 0; i < 20; i++ {
+		provider := "zip"
+		if i%2 == 0 {
+			provider = "git"
+		}
+		proj := &Project{Provider: provider}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := s.Acquire(context.Background(), proj); err != nil {
+				t.Error(err)
+				return
+			}
+
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			if proj.Provider == "git" {
+				git++
+				if git > maxGit {
+					maxGit = git
+				}
+			}
+			mutex.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mutex.Lock()
+			running--
+			if proj.Provider == "git" {
+				git--
+			}
+			mutex.Unlock()
+
+			s.Release(proj)
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning > 3 {
+		t.Errorf("%d projects ran at once, the limit is 3", maxRunning)
+	}
+	if maxGit > 1 {
+		t.Errorf("%d git projects ran at once, the limit is 1", maxGit)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		held *Project
+	}{
+		{"waiting for a job", &Project{Provider: "zip"}},
+		{"waiting for the provider", &Project{Provider: "git"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewScheduler(Concurrency{Jobs: 1, Providers: map[string]int{"git": 1}})
+			if err := s.Acquire(context.Background(), test.held); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- s.Acquire(ctx, &Project{Provider: "git"})
+			}()
+
+			cancel()
+			select {
+			case err := <-done:
+				if err != context.Canceled {
+					t.Errorf("got error %v, expected %v", err, context.Canceled)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Acquire didn't return when its context was cancelled")
+			}
+
+			// Nothing is held by the cancelled Acquire, so once the first
+			// project is done another git project can go.
+			s.Release(test.held)
+
+			ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.Acquire(ctx, &Project{Provider: "git"}); err != nil {
+				t.Errorf("a slot is still held after cancelling: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Staging builds the source code in a sibling directory of the destination
+// and swaps it into place once everything has succeeded, so a failed run
+// never leaves the destination half-populated.
+type Staging struct {
+	// Destination is where the source code should end up.
+	Destination string
+
+	// Dir is the sibling directory the source code is built in.
+	Dir string
+
+	// Previous is where the previous destination is kept for rollback.
+	Previous string
+}
+
+// NewStaging creates a new Staging for a destination.
+func NewStaging(destination string) (*Staging, error) {
+	dest, err := filepath.Abs(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(Staging)
+
+	s.Destination = dest
+	s.Dir = dest + ".tasc-staging"
+	s.Previous = dest + ".tasc-previous"
+
+	return s, nil
+}
+
+// Contains reports whether path is inside the destination. Such a path would
+// be moved away by the swap.
+func (s *Staging) Contains(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	return abs == s.Destination ||
+		strings.HasPrefix(abs, s.Destination+string(os.PathSeparator))
+}
+
+// Prepare creates an empty staging directory, removing anything left behind
+// by an earlier run.
+func (s *Staging) Prepare() error {
+	if err := os.RemoveAll(s.Dir); err != nil {
+		return err
+	}
+
+	return os.MkdirAll(s.Dir, 0755)
+}
+
+// Commit moves the destination out of the way to Previous, replacing any
+// earlier Previous, and renames the staging directory to the destination.
+func (s *Staging) Commit() error {
+	if err := os.RemoveAll(s.Previous); err != nil {
+		return err
+	}
+
+	_, err := os.Stat(s.Destination)
+	existed := err == nil
+	if existed {
+		if err := os.Rename(s.Destination, s.Previous); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(s.Dir, s.Destination); err != nil {
+		// Put the previous tree back so the destination keeps working.
+		if existed {
+			os.Rename(s.Previous, s.Destination)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Discard removes the staging directory.
+func (s *Staging) Discard() error {
+	return os.RemoveAll(s.Dir)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StateFilename is the name of the file in the destination that records what
+// was assembled there.
+const StateFilename = ".tasc-state"
+
+// StateProject records how a project was assembled in a destination.
+type StateProject struct {
+	Provider string `yaml:"provider"`
+	Source   string `yaml:"source"`
+	Resolved string `yaml:"resolved"`
+
+	// Destination is where the project was placed, relative to the
+	// destination, including any rename.
+	Destination string `yaml:"destination"`
+
+	// Paths are the files and directories the project placed, relative to
+	// the destination.
+	Paths []string `yaml:"paths"`
+}
+
+// State records what was assembled in a destination, so that it can be
+// updated incrementally by a later run.
+type State struct {
+	Projects map[string]*StateProject `yaml:"projects"`
+
+	// Needed to ensure exclusive access to the Projects map with concurrent
+	// goroutines.
+	mutex sync.Mutex
+}
+
+// Get returns the state of a project, or nil if it was not assembled.
+func (s *State) Get(name string) *StateProject {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.Projects[name]
+}
+
+// Set records the state of a project.
+func (s *State) Set(name string, sp *StateProject) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Projects == nil {
+		s.Projects = make(map[string]*StateProject)
+	}
+
+	s.Projects[name] = sp
+}
+
+// Delete forgets a project.
+func (s *State) Delete(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.Projects, name)
+}
+
+// Names returns the names of all projects in the state.
+func (s *State) Names() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var names []string
+	for name := range s.Projects {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Load a state from a yaml filename. A missing file is an empty state.
+func (s *State) Load(filename string) error {
+	sb, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return LoadError{"Error loading state"}
+	}
+
+	err = yaml.Unmarshal(sb, s)
+	if err != nil {
+		return ParseError{"Error parsing state"}
+	}
+
+	return nil
+}
+
+// Save writes the state to a yaml filename.
+func (s *State) Save(filename string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sb, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, sb, 0644)
+}
+
+// relativePaths converts absolute paths to paths relative to dir. Paths
+// outside dir are dropped, so they are never removed by a later update.
+func relativePaths(dir string, paths []string) []string {
+	var rel []string
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+
+		r, err := filepath.Rel(dir, path)
+		if err != nil || r == "." || r == ".." ||
+			strings.HasPrefix(r, ".."+string(os.PathSeparator)) {
+			continue
+		}
+		rel = append(rel, r)
+	}
+
+	return rel
+}
+
+// absolutePaths converts paths relative to dir to absolute paths.
+func absolutePaths(dir string, paths []string) []string {
+	var abs []string
+
+	for _, path := range paths {
+		abs = append(abs, filepath.Join(dir, path))
+	}
+
+	return abs
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 	"sync"
+	"tasc/fetcher"
 	"tasc/patcher"
+	"time"
 )
 
 // Tasc is the main structure for the application. It is responsible for
@@ -11,56 +17,383 @@ import (
 type Tasc struct {
 	manifest    Manifest
 	destination string
+
+	// lock collects the resolved identity of each project. When locked is
+	// true, projects are instead fetched at the identity found in lock.
+	lock   *Lockfile
+	locked bool
+
+	// jobs overrides the number of concurrent jobs set in the manifest.
+	jobs int
+
+	// cache keeps fetched content between runs. It is nil when caching is
+	// disabled.
+	cache *fetcher.Cache
+
+	// report collects what happened to each project.
+	report *RunReport
+
+	// failFast aborts the assembly as soon as a blocking project fails, in
+	// which case aborted is set.
+	failFast bool
+	aborted  bool
+
+	// state records what is assembled in the destination. With update,
+	// projects found in previous are updated where they are instead of being
+	// fetched from scratch, and projects no longer in the manifest are
+	// removed.
+	state    *State
+	previous *State
+	update   bool
+
+	// redactor masks secrets in errors before they are reported.
+	redactor *Redactor
+
+	// export places every git and svn project without its .git or .svn
+	// directory.
+	export bool
+}
+
+// source returns the source of the project as it is recorded in the lockfile
+// and the state, without credentials or secrets.
+func (t *Tasc) source(proj *Project) string {
+	return lockSource(proj.Fetcher.GetSource(), t.redactor)
+}
+
+// pin pins the project's fetcher to the identity recorded in the lockfile.
+func (t *Tasc) pin(proj *Project) error {
+	locked := t.lock.Get(proj)
+	if locked == nil {
+		return LockError{fmt.Sprintf("%s is not in the lockfile", proj.Name)}
+	}
+
+	if locked.Provider != proj.Provider ||
+		locked.Source != t.source(proj) {
+		return LockError{fmt.Sprintf(
+			"%s has changed since the lockfile was written", proj.Name,
+		)}
+	}
+
+	pinner, ok := proj.Fetcher.(fetcher.Pinner)
+	if !ok {
+		return LockError{fmt.Sprintf("%s can not be pinned", proj.Name)}
+	}
+	pinner.Pin(locked.Resolved)
+
+	return nil
+}
+
+// fetch pins the project if needed, fetches it within its timeout, and
+// records the identity it resolved to.
+func (t *Tasc) fetch(ctx context.Context, proj *Project) error {
+	if t.locked {
+		if err := t.pin(proj); err != nil {
+			return err
+		}
+	}
+
+	if cacher, ok := proj.Fetcher.(fetcher.Cacher); ok && t.cache != nil {
+		cacher.SetCache(t.cache)
+	}
+
+	if exporter, ok := proj.Fetcher.(fetcher.Exporter); ok && t.export {
+		exporter.Export()
+	}
+
+	if redacter, ok := proj.Fetcher.(fetcher.Redacter); ok {
+		redacter.SetRedact(t.redactor.Redact)
+	}
+
+	if proj.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, proj.Timeout)
+		defer cancel()
+	}
+
+	var prev *StateProject
+	if t.update {
+		prev = t.previous.Get(proj.Name)
+	}
+
+	updater, ok := proj.Fetcher.(fetcher.Updater)
+	switch {
+	case prev != nil && ok && prev.Provider == proj.Provider &&
+		prev.Source == t.source(proj) &&
+		prev.Destination == proj.Destination:
+		err := updater.Update(ctx, t.destination, fetcher.Placement{
+			Resolved: prev.Resolved,
+			Paths:    absolutePaths(t.destination, prev.Paths),
+		})
+		if err != nil {
+			return err
+		}
+	case prev != nil:
+		// The project has changed, or moved, so remove it and fetch it from
+		// scratch.
+		if err := t.remove(prev.Paths); err != nil {
+			return err
+		}
+		fallthrough
+	default:
+		if err := proj.Fetcher.Fetch(ctx, t.destination); err != nil {
+			return err
+		}
+	}
+
+	t.record(proj)
+
+	return nil
 }
 
-// Fetch fetches the project and updates the progress.
-func Fetch(proj *Project, dest string, prog *Progress) {
+// record adds the project to the lockfile and the state.
+func (t *Tasc) record(proj *Project) {
+	sp := &StateProject{
+		Provider:    proj.Provider,
+		Source:      t.source(proj),
+		Destination: proj.Destination,
+	}
+
+	if pinner, ok := proj.Fetcher.(fetcher.Pinner); ok {
+		sp.Resolved = pinner.Resolved()
+		if !t.locked {
+			t.lock.Set(proj, sp.Source, sp.Resolved)
+		}
+	}
+
+	if placer, ok := proj.Fetcher.(fetcher.Placer); ok {
+		sp.Paths = relativePaths(t.destination, placer.Paths())
+	}
+
+	t.state.Set(proj.Name, sp)
+}
+
+// remove removes paths relative to the destination.
+func (t *Tasc) remove(paths []string) error {
+	for _, path := range absolutePaths(t.destination, paths) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prune removes the projects that were assembled by an earlier run but are no
+// longer in the manifest. Paths now used by another project are kept.
+func (t *Tasc) prune() error {
+	names := make(map[string]bool)
+	for _, proj := range t.manifest.Projects {
+		names[proj.Name] = true
+	}
+
+	used := make(map[string]bool)
+	for _, name := range t.state.Names() {
+		if names[name] {
+			for _, path := range t.state.Get(name).Paths {
+				used[path] = true
+			}
+		}
+	}
+
+	for _, name := range t.previous.Names() {
+		if names[name] {
+			continue
+		}
+
+		for _, path := range t.previous.Get(name).Paths {
+			if used[path] {
+				continue
+			}
+			if err := t.remove([]string{path}); err != nil {
+				return err
+			}
+		}
+		t.state.Delete(name)
+	}
+
+	return nil
+}
+
+// WriteState writes the state file into the destination.
+func (t *Tasc) WriteState() error {
+	return t.state.Save(filepath.Join(t.destination, StateFilename))
+}
+
+// Fetch fetches the project, updates the progress and adds the project to the
+// report.
+func (t *Tasc) Fetch(ctx context.Context, proj *Project, prog *Progress) error {
+	pr := &ProjectReport{
+		Name:     t.redactor.Redact(proj.Name),
+		Provider: proj.Provider,
+		Start:    time.Now(),
+	}
+
 	prog.Add(proj, StateProcessing).Report()
-	if err := proj.Fetcher.Fetch(dest); err != nil {
-		prog.Add(proj, StateFailed).Report()
+
+	// Errors of fetchers and the commands they run may contain the source,
+	// and with it any token in the URL.
+	err := t.redactor.Error(t.fetch(ctx, proj))
+
+	pr.End = time.Now()
+	pr.Duration = pr.End.Sub(pr.Start).Seconds()
+	if counter, ok := proj.Fetcher.(fetcher.Counter); ok {
+		n := counter.BytesFetched()
+		pr.Bytes = &n
+	}
+
+	if err != nil {
+		pr.State = StateFailed.String()
+		pr.Error = err.Error()
+		prog.AddError(proj, StateFailed, err).Report()
 	} else {
+		if pinner, ok := proj.Fetcher.(fetcher.Pinner); ok {
+			pr.Resolved = pinner.Resolved()
+		}
+		pr.State = StateSuccess.String()
 		prog.Add(proj, StateSuccess).Report()
 	}
+	t.report.Add(pr)
+
+	return err
 }
 
-// Assemble the source code for the project.
-func (t *Tasc) Assemble(c chan string) {
-	progress := NewProgress(c)
+// skip marks a project that won't be fetched as failed or skipped.
+func (t *Tasc) skip(proj *Project, prog *Progress, state ProjectState, err error) {
+	now := time.Now()
+	err = t.redactor.Error(err)
+	t.report.Add(&ProjectReport{
+		Name:     t.redactor.Redact(proj.Name),
+		Provider: proj.Provider,
+		State:    state.String(),
+		Error:    err.Error(),
+		Start:    now,
+		End:      now,
+	})
+
+	prog.AddError(proj, state, err).Report()
+}
+
+// job tracks a project while the source code is assembled. done is closed
+// once the project has been processed, and ok is true if it was fetched.
+type job struct {
+	done chan struct{}
+	ok   bool
+}
+
+// Assemble the source code for the project. Each project is fetched as soon
+// as all of its dependencies have been fetched and the scheduler has a free
+// slot for it. Projects with a failed dependency are skipped. Once ctx is
+// cancelled, running fetches are aborted and no new ones are started.
+func (t *Tasc) Assemble(ctx context.Context, progress *Progress) {
 	progress.QueueProjects(t.manifest.Projects)
 
-	wg := &sync.WaitGroup{}
-	wg.Add(len(t.manifest.Projects))
+	// Start from the previous state, so projects that fail to update are
+	// still known to the next run.
+	if t.update {
+		for _, name := range t.previous.Names() {
+			t.state.Set(name, t.previous.Get(name))
+		}
+	}
+
+	// With fail-fast, a failed blocking project cancels everything else.
+	ctx, cancel := context.WithCancelCause(ctx)
+	var abort sync.Once
 
-	// Synchronous and asynchronous projects.
-	sProjs, aProjs := t.manifest.BlockingProjects()
+	jobs := make(map[*Project]*job)
+	for _, proj := range t.manifest.Projects {
+		jobs[proj] = &job{done: make(chan struct{})}
+	}
 
-	// First lets work through the synchronous projects.
-	sort.Sort(sProjs)
-	for _, sProj := range sProjs {
-		Fetch(sProj, t.destination, progress)
-		wg.Done()
+	concurrency := t.manifest.Concurrency
+	if t.jobs > 0 {
+		concurrency.Jobs = t.jobs
 	}
+	scheduler := NewScheduler(concurrency)
 
-	// Now the asyncronous ones.
-	sort.Sort(aProjs)
-	for _, aProj := range aProjs {
+	wg := &sync.WaitGroup{}
+	wg.Add(len(t.manifest.Projects))
+
+	projs := append(SortProject(nil), t.manifest.Projects...)
+	sort.Sort(projs)
+	for _, proj := range projs {
 		go func(p *Project) {
-			Fetch(p, t.destination, progress)
-			wg.Done()
-		}(aProj)
+			defer wg.Done()
+
+			j := jobs[p]
+			defer close(j.done)
+
+			for _, dep := range p.dependencies {
+				d := jobs[dep]
+				<-d.done
+
+				if !d.ok {
+					err := fmt.Errorf("dependency %s was not fetched", dep.Name)
+					t.skip(p, progress, StateSkipped, err)
+					return
+				}
+			}
+
+			if ctx.Err() != nil {
+				t.skip(p, progress, StateSkipped, context.Cause(ctx))
+				return
+			}
+
+			if err := scheduler.Acquire(ctx, p); err != nil {
+				t.skip(p, progress, StateSkipped, context.Cause(ctx))
+				return
+			}
+			j.ok = t.Fetch(ctx, p, progress) == nil
+			scheduler.Release(p)
+
+			if !j.ok && p.Blocking && t.failFast {
+				abort.Do(func() {
+					t.aborted = true
+					cancel(fmt.Errorf("blocking project %s failed", p.Name))
+				})
+			}
+		}(proj)
 	}
 
 	go func() {
 		wg.Wait()
-		close(c)
+
+		if t.update && ctx.Err() == nil {
+			if err := t.prune(); err != nil {
+				t.report.Add(&ProjectReport{
+					Name:  "(removed projects)",
+					State: StateFailed.String(),
+					Error: t.redactor.Redact(err.Error()),
+				})
+			}
+		}
+
+		cancel(nil)
+		t.report.Finish()
+		close(progress.C)
 	}()
 }
 
-// Patch performs the patches.
+// WriteLock writes the lockfile if every project resolved to an identity.
+// Nothing is written when assembling in locked mode.
+func (t *Tasc) WriteLock(filename string) (bool, error) {
+	if t.locked || t.lock.Len() < len(t.manifest.Projects) {
+		return false, nil
+	}
+
+	return true, t.lock.Save(filename)
+}
+
+// Patch performs the patches. When updating, patches that were already
+// applied by an earlier run are skipped.
 func (t *Tasc) Patch() patcher.PatchResults {
 	var results patcher.PatchResults
 
 	for _, patch := range t.manifest.Patches {
+		if checker, ok := patch.Patcher.(patcher.Checker); ok && t.update && checker.Applied() {
+			results = append(results, &patcher.PatchResult{Patcher: patch.Patcher, Skipped: true})
+			continue
+		}
+
 		result := patch.Patcher.Patch()
 		results = append(results, result)
 	}
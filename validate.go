@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"tasc/fetcher"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is a problem found in the manifest, at a line and column.
+type ValidationError struct {
+	Line   int
+	Column int
+	msg    string
+}
+
+// Error returns the validation error message.
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.msg)
+}
+
+// ValidationErrors are all the problems found in the manifest.
+type ValidationErrors []ValidationError
+
+// Error returns the validation error messages, one per line.
+func (e ValidationErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Sort puts the errors in the order they appear in the manifest.
+func (e ValidationErrors) Sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].Line != e[j].Line {
+			return e[i].Line < e[j].Line
+		}
+		return e[i].Column < e[j].Column
+	})
+}
+
+// The keys and values the manifest understands.
+var (
+	manifestKeys = []string{"version", "params", "projects", "patches", "concurrency"}
+	paramKeys    = []string{"secret"}
+
+	// The project keys of each manifest version.
+	projectKeys = map[int][]string{
+		1: {
+			"provider", "source", "destination", "rename", "version", "tags",
+			"depends_on", "timeout", "checksum",
+		},
+		2: {
+			"provider", "source", "destination", "rename", "version",
+			"blocking", "sticky", "depends_on", "timeout", "checksum", "auth",
+			"depth", "single_branch", "submodules", "lfs", "export",
+			"username", "password", "format", "strip_components", "subpath",
+		},
+	}
+	projectTextKeys = []string{
+		"provider", "source", "destination", "rename", "version", "timeout",
+		"checksum", "submodules", "username", "password", "format", "subpath",
+	}
+	gitKeys          = []string{"auth", "depth", "single_branch", "submodules", "lfs"}
+	svnKeys          = []string{"username", "password"}
+	subtreeKeys      = []string{"strip_components", "subpath"}
+	subtreeProviders = []string{"zip", "git", "local"}
+	submoduleValues  = []string{"true", "false", "recursive"}
+	authKeys         = []string{"ssh_key", "token", "username", "netrc", "credential_helper"}
+	patchKeys        = []string{"type", "source", "destination"}
+	concurrencyKeys  = []string{"jobs", "providers"}
+	projectProviders = []string{"git", "svn", "hg", "local", "zip"}
+	projectTags      = []string{"blocking", "sticky"}
+	patchTypes       = []string{"file", "patch_file"}
+)
+
+// validator collects the problems found while walking a manifest document.
+type validator struct {
+	version int
+	errs    ValidationErrors
+}
+
+// errorf records a problem at the position of a node.
+func (v *validator) errorf(node *yaml.Node, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{
+		Line:   node.Line,
+		Column: node.Column,
+		msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+// contains reports whether s is one of values.
+func contains(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mapping checks that a node is a mapping with only known keys, and returns
+// the value of each key.
+func (v *validator) mapping(node *yaml.Node, what string, keys []string) map[string]*yaml.Node {
+	return v.versionedMapping(node, what, keys, nil)
+}
+
+// versionedMapping is like mapping, but keys that are in newer, the keys of
+// the newest manifest version, are reported as needing that version.
+func (v *validator) versionedMapping(node *yaml.Node, what string, keys, newer []string) map[string]*yaml.Node {
+	values := make(map[string]*yaml.Node)
+
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "%s must be a mapping", what)
+		return values
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		switch {
+		case !contains(keys, key.Value) && contains(newer, key.Value):
+			v.errorf(key, "%q requires version: %d", key.Value, ManifestVersion)
+		case !contains(keys, key.Value):
+			v.errorf(key, "unknown key %q in %s, expected one of: %s",
+				key.Value, what, strings.Join(keys, ", "))
+		case values[key.Value] != nil:
+			v.errorf(key, "duplicate key %q in %s", key.Value, what)
+		default:
+			values[key.Value] = value
+		}
+	}
+
+	return values
+}
+
+// sequence checks that a node is a sequence and returns its items.
+func (v *validator) sequence(node *yaml.Node, what string) []*yaml.Node {
+	if node.Kind != yaml.SequenceNode {
+		v.errorf(node, "%s must be a list", what)
+		return nil
+	}
+
+	return node.Content
+}
+
+// scalar checks that a node is a single value and returns it.
+func (v *validator) scalar(node *yaml.Node, what string) (string, bool) {
+	if node.Kind != yaml.ScalarNode {
+		v.errorf(node, "%s must be a single value", what)
+		return "", false
+	}
+
+	return node.Value, true
+}
+
+// text checks that the values of keys that hold text are single values, and
+// drops those that aren't so they are not reported again. A placeholder that
+// is the whole unquoted value, like version: {branch}, is a mapping to YAML.
+func (v *validator) text(values map[string]*yaml.Node, keys []string) {
+	for _, key := range keys {
+		if node, ok := values[key]; ok && node.Kind != yaml.ScalarNode {
+			v.errorf(node, "%s must be a single value; quote placeholders like \"{branch}\"", key)
+			delete(values, key)
+		}
+	}
+}
+
+// oneOf checks that a node is one of the allowed values.
+func (v *validator) oneOf(node *yaml.Node, what string, allowed []string) {
+	value, ok := v.scalar(node, what)
+	if ok && !contains(allowed, value) {
+		v.errorf(node, "unknown %s %q, expected one of: %s",
+			what, value, strings.Join(allowed, ", "))
+	}
+}
+
+// boolean checks that a node is true or false.
+func (v *validator) boolean(node *yaml.Node, what string) {
+	var b bool
+	if node.Kind != yaml.ScalarNode || node.Decode(&b) != nil {
+		v.errorf(node, "%s must be true or false", what)
+	}
+}
+
+// names returns the names listed in a single value or a list, like
+// depends_on, with the node of each.
+func (v *validator) names(node *yaml.Node, what string) map[*yaml.Node]string {
+	names := make(map[*yaml.Node]string)
+
+	if node.Kind == yaml.ScalarNode {
+		names[node] = node.Value
+		return names
+	}
+
+	for _, item := range v.sequence(node, what) {
+		if name, ok := v.scalar(item, what); ok {
+			names[item] = name
+		}
+	}
+
+	return names
+}
+
+// validateManifest checks a parsed manifest document for problems such as
+// unknown keys, missing sources, unknown providers and tags, and duplicate
+// project names. It returns nil if the manifest is valid.
+func validateManifest(doc *yaml.Node) ValidationErrors {
+	v := &validator{}
+
+	if doc.Kind == 0 {
+		// An empty manifest has nothing to do, but nothing wrong with it.
+		return nil
+	}
+
+	root := doc
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+
+	top := v.mapping(root, "the manifest", manifestKeys)
+
+	v.version = 1
+	if node, ok := top["version"]; ok {
+		version, err := strconv.Atoi(node.Value)
+		switch {
+		case err != nil || node.Kind != yaml.ScalarNode:
+			v.errorf(node, "version must be a number")
+		case version < 1 || version > ManifestVersion:
+			v.errorf(node, "unsupported manifest version %d, expected 1 to %d",
+				version, ManifestVersion)
+		default:
+			v.version = version
+		}
+	}
+
+	if node, ok := top["params"]; ok {
+		v.params(node)
+	}
+
+	if node, ok := top["concurrency"]; ok {
+		v.concurrency(node)
+	}
+
+	if node, ok := top["projects"]; ok {
+		v.projects(node)
+	}
+
+	if node, ok := top["patches"]; ok {
+		for _, patch := range v.sequence(node, "patches") {
+			values := v.mapping(patch, "a patch", patchKeys)
+
+			if source, ok := values["source"]; !ok || source.Kind == yaml.ScalarNode && source.Value == "" {
+				v.errorf(patch, "patch has no source")
+			}
+			v.text(values, patchKeys)
+
+			if typ, ok := values["type"]; ok {
+				v.oneOf(typ, "patch type", patchTypes)
+			}
+		}
+	}
+
+	v.errs.Sort()
+
+	return v.errs
+}
+
+// params validates the declarations of params.
+func (v *validator) params(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "params must be a mapping")
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, param := node.Content[i], node.Content[i+1]
+
+		values := v.mapping(param, fmt.Sprintf("param %s", name.Value), paramKeys)
+		if secret, ok := values["secret"]; ok {
+			v.boolean(secret, "secret")
+		}
+	}
+}
+
+// concurrency validates the concurrency settings.
+func (v *validator) concurrency(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		if _, err := strconv.Atoi(node.Value); err != nil {
+			v.errorf(node, "concurrency must be a number of jobs or a mapping")
+		}
+		return
+	}
+
+	values := v.mapping(node, "concurrency", concurrencyKeys)
+	if jobs, ok := values["jobs"]; ok {
+		if _, err := strconv.Atoi(jobs.Value); err != nil {
+			v.errorf(jobs, "jobs must be a number")
+		}
+	}
+
+	if providers, ok := values["providers"]; ok {
+		limits := v.mapping(providers, "concurrency providers", projectProviders)
+		for _, limit := range limits {
+			if _, err := strconv.Atoi(limit.Value); err != nil {
+				v.errorf(limit, "provider limit must be a number")
+			}
+		}
+	}
+}
+
+// projects validates the list of projects.
+func (v *validator) projects(node *yaml.Node) {
+	// Project names, to find duplicates and unknown dependencies.
+	names := make(map[string]*yaml.Node)
+	dependencies := make(map[*yaml.Node]string)
+
+	for _, project := range v.sequence(node, "projects") {
+		values := v.versionedMapping(project, "a project",
+			projectKeys[v.version], projectKeys[ManifestVersion])
+
+		source, ok := values["source"]
+		if !ok || source.Kind == yaml.ScalarNode && source.Value == "" {
+			v.errorf(project, "project has no source")
+		}
+
+		v.text(values, projectTextKeys)
+
+		provider := "zip"
+		if node, ok := values["provider"]; ok {
+			v.oneOf(node, "provider", projectProviders)
+			provider = node.Value
+		}
+
+		for _, key := range gitKeys {
+			if node, ok := values[key]; ok && provider != "git" {
+				v.errorf(node, "%s is only supported by the git provider", key)
+			}
+		}
+
+		for _, key := range svnKeys {
+			if node, ok := values[key]; ok && provider != "svn" {
+				v.errorf(node, "%s is only supported by the svn provider", key)
+			}
+		}
+
+		if format, ok := values["format"]; ok {
+			v.oneOf(format, "format", fetcher.ArchiveFormats)
+			if provider != "zip" {
+				v.errorf(format, "format is only supported by the zip provider")
+			}
+		}
+
+		for _, key := range subtreeKeys {
+			if node, ok := values[key]; ok && !contains(subtreeProviders, provider) {
+				v.errorf(node, "%s is only supported by the zip, git and local providers", key)
+			}
+		}
+
+		if strip, ok := values["strip_components"]; ok {
+			if n, err := strconv.Atoi(strip.Value); err != nil || n < 0 {
+				v.errorf(strip, "strip_components must be a number of directories")
+			}
+		}
+
+		if subpath, ok := values["subpath"]; ok {
+			path := filepath.ToSlash(filepath.Clean(subpath.Value))
+			if filepath.IsAbs(subpath.Value) || path == ".." || strings.HasPrefix(path, "../") {
+				v.errorf(subpath, "subpath must be a relative path inside the project")
+			}
+		}
+
+		if auth, ok := values["auth"]; ok {
+			v.mapping(auth, "auth", authKeys)
+		}
+
+		if depth, ok := values["depth"]; ok {
+			if n, err := strconv.Atoi(depth.Value); err != nil || n < 0 {
+				v.errorf(depth, "depth must be a number of commits, or 0 for all of them")
+			}
+		}
+
+		if singleBranch, ok := values["single_branch"]; ok {
+			v.boolean(singleBranch, "single_branch")
+		}
+
+		if submodules, ok := values["submodules"]; ok {
+			v.oneOf(submodules, "submodules", submoduleValues)
+		}
+
+		if lfs, ok := values["lfs"]; ok {
+			v.boolean(lfs, "lfs")
+		}
+
+		if export, ok := values["export"]; ok {
+			v.boolean(export, "export")
+			if provider != "git" && provider != "svn" {
+				v.errorf(export, "export is only supported by the git and svn providers")
+			}
+		}
+
+		if tags, ok := values["tags"]; ok {
+			for _, tag := range v.sequence(tags, "tags") {
+				v.oneOf(tag, "tag", projectTags)
+			}
+		}
+
+		for _, key := range projectTags {
+			if value, ok := values[key]; ok {
+				v.boolean(value, key)
+			}
+		}
+
+		if timeout, ok := values["timeout"]; ok {
+			if _, err := time.ParseDuration(timeout.Value); err != nil {
+				v.errorf(timeout, "invalid timeout %q, use a duration such as 90s or 10m", timeout.Value)
+			}
+		}
+
+		if checksum, ok := values["checksum"]; ok {
+			if _, err := fetcher.ParseChecksum(checksum.Value); err != nil {
+				v.errorf(checksum, "%s", err)
+			}
+		}
+
+		if dependsOn, ok := values["depends_on"]; ok {
+			for node, name := range v.names(dependsOn, "depends_on") {
+				dependencies[node] = name
+			}
+		}
+
+		// Name the project the same way NewProject does.
+		var spec ProjectSpec
+		if rename, ok := values["rename"]; ok {
+			spec.Rename = rename.Value
+		}
+		if source, ok := values["source"]; ok {
+			spec.Source = source.Value
+		}
+		name := InferProjectName(spec)
+
+		if first, ok := names[name]; ok {
+			v.errorf(project, "duplicate project name %q, also used on line %d. Use rename to tell them apart",
+				name, first.Line)
+		} else {
+			names[name] = project
+		}
+	}
+
+	for node, name := range dependencies {
+		if _, ok := names[name]; !ok {
+			v.errorf(node, "depends on unknown project %q", name)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		errs     []string
+	}{
+		{
+			name:     "empty",
+			manifest: "",
+		},
+		{
+			name: "valid version 1",
+			manifest: `
+projects:
+  - source: https://github.com/moodle/moodle.git
+    provider: git
+    version: MOODLE_39_STABLE
+    tags: [blocking]
+  - source: https://example.com/plugin.zip
+    destination: mod
+    depends_on: moodle.git
+patches:
+  - source: fix.patch
+    destination: moodle.git
+`,
+		},
+		{
+			name: "valid version 2",
+			manifest: `
+version: 2
+concurrency:
+  jobs: 4
+  providers:
+    git: 2
+projects:
+  - source: https://github.com/moodle/moodle.git
+    provider: git
+    blocking: true
+    depth: 1
+    single_branch: true
+    submodules: recursive
+  - source: https://example.com/plugin.tar.gz
+    checksum: sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae
+    strip_components: 1
+    subpath: src
+    timeout: 5m
+    depends_on: [moodle.git]
+`,
+		},
+		{
+			name:     "not a mapping",
+			manifest: "- projects",
+			errs:     []string{"1:1: the manifest must be a mapping"},
+		},
+		{
+			name: "unknown keys",
+			manifest: `
+project: []
+projects:
+  - source: a
+    sauce: b
+`,
+			errs: []string{
+				`2:1: unknown key "project" in the manifest, expected one of: version, params, projects, patches, concurrency`,
+				`5:5: unknown key "sauce" in a project, expected one of: provider, source, destination, rename, version, tags, depends_on, timeout, checksum`,
+			},
+		},
+		{
+			name: "version 2 keys in version 1",
+			manifest: `
+projects:
+  - source: a
+    blocking: true
+    depth: 1
+    strip_components: 1
+`,
+			errs: []string{
+				`4:5: "blocking" requires version: 2`,
+				`5:5: "depth" requires version: 2`,
+				`6:5: "strip_components" requires version: 2`,
+			},
+		},
+		{
+			name:     "unsupported version",
+			manifest: "version: 3",
+			errs:     []string{"1:10: unsupported manifest version 3, expected 1 to 2"},
+		},
+		{
+			name:     "version not a number",
+			manifest: "version: two",
+			errs:     []string{"1:10: version must be a number"},
+		},
+		{
+			name: "missing source and unknown provider",
+			manifest: `
+projects:
+  - provider: cvs
+    destination: a
+`,
+			errs: []string{
+				"3:5: project has no source",
+				`3:15: unknown provider "cvs", expected one of: git, svn, hg, local, zip`,
+			},
+		},
+		{
+			name: "options of other providers",
+			manifest: `
+version: 2
+projects:
+  - source: a.zip
+    depth: 1
+    username: me
+    export: true
+  - source: b
+    provider: svn
+    format: zip
+`,
+			errs: []string{
+				"5:12: depth is only supported by the git provider",
+				"6:15: username is only supported by the svn provider",
+				"7:13: export is only supported by the git and svn providers",
+				"10:13: format is only supported by the zip provider",
+			},
+		},
+		{
+			name: "invalid values",
+			manifest: `
+version: 2
+projects:
+  - source: a
+    provider: git
+    depth: -1
+    single_branch: sometimes
+    submodules: all
+    timeout: soon
+    subpath: ../up
+`,
+			errs: []string{
+				"6:12: depth must be a number of commits, or 0 for all of them",
+				"7:20: single_branch must be true or false",
+				`8:17: unknown submodules "all", expected one of: true, false, recursive`,
+				`9:14: invalid timeout "soon", use a duration such as 90s or 10m`,
+				"10:14: subpath must be a relative path inside the project",
+			},
+		},
+		{
+			name: "unknown tag",
+			manifest: `
+projects:
+  - source: a
+    tags: [blocking, slow]
+`,
+			errs: []string{`4:22: unknown tag "slow", expected one of: blocking, sticky`},
+		},
+		{
+			name: "unquoted placeholder",
+			manifest: `
+projects:
+  - source: https://github.com/moodle/moodle.git
+    provider: git
+    version: {branch:-master}
+patches:
+  - source: {patch}
+`,
+			errs: []string{
+				`5:14: version must be a single value; quote placeholders like "{branch}"`,
+				`7:13: source must be a single value; quote placeholders like "{branch}"`,
+			},
+		},
+		{
+			name: "duplicate names and unknown dependencies",
+			manifest: `
+projects:
+  - source: https://example.com/a/plugin.zip
+  - source: https://example.com/b/plugin.zip
+    depends_on: missing
+  - source: https://example.com/b/plugin.zip
+    rename: other
+`,
+			errs: []string{
+				`4:5: duplicate project name "plugin.zip", also used on line 3. Use rename to tell them apart`,
+				`5:17: depends on unknown project "missing"`,
+			},
+		},
+		{
+			name: "invalid patches",
+			manifest: `
+patches:
+  - destination: a
+  - source: b.patch
+    type: diff
+`,
+			errs: []string{
+				"3:5: patch has no source",
+				`5:11: unknown patch type "diff", expected one of: file, patch_file`,
+			},
+		},
+		{
+			name: "invalid params and concurrency",
+			manifest: `
+params:
+  token:
+    secret: maybe
+concurrency:
+  jobs: many
+`,
+			errs: []string{
+				"4:13: secret must be true or false",
+				"6:9: jobs must be a number",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(test.manifest), &doc); err != nil {
+				t.Fatal(err)
+			}
+
+			var errs []string
+			for _, err := range validateManifest(&doc) {
+				errs = append(errs, err.Error())
+			}
+
+			if !reflect.DeepEqual(errs, test.errs) {
+				t.Errorf("got errors:\n%q\nexpected:\n%q", errs, test.errs)
+			}
+		})
+	}
+}